@@ -9,5 +9,5 @@ import (
 func main() {
 	cli := cmd.NewCLI()
 	cli.RegisterCommands()
-	cli.Run(os.Args)
+	os.Exit(cli.Exec(os.Args))
 }
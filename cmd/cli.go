@@ -1,70 +1,187 @@
 package cmd
 
 import (
-	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"io/ioutil"
-	"os"
+	"strings"
 
 	. "github.com/RichardWeiYang/bcex/lib"
 	"github.com/jawher/mow.cli"
 )
 
-var bcexKey *string
+var bcexKey, configPath, storeKind, keyCommand *string
 
-type ExchangeKey struct {
-	AccessKeyId string `json:"AccessKeyId"`
-	SecretKeyId string `json:"SecretKeyId"`
-}
+// ParseCredentials takes values in the order of the credentials of
+// exchange name, or as FIELD=VALUE.
+func ParseCredentials(name string, values []string) (Credentials, error) {
+	fields := CredentialsOf(name)
+	if fields == nil {
+		return nil, fmt.Errorf("Exchange %s is not found", name)
+	}
 
-var keys map[string]ExchangeKey
+	var ordered []CredentialField
+	for _, f := range fields {
+		if !f.Named {
+			ordered = append(ordered, f)
+		}
+	}
 
-func Init(bcexkey string) {
-	length := len(bcexkey)
-	if length == 0 {
-		fmt.Println("BCEX Key is needed, use -k or ENV to set it")
-		os.Exit(1)
+	c := Credentials{}
+	i := 0
+	for _, v := range values {
+		if kv := strings.SplitN(v, "=", 2); len(kv) == 2 {
+			named := false
+			for _, f := range fields {
+				named = named || f.Name == kv[0]
+			}
+			if named {
+				c[kv[0]] = kv[1]
+				continue
+			}
+		}
+		if i >= len(ordered) {
+			return nil, fmt.Errorf("%s takes %d credentials in order: %s", name,
+				len(ordered), FieldNames(fields))
+		}
+		c[ordered[i].Name] = v
+		i++
 	}
+	return c, ValidateCredentials(name, c)
+}
 
-	if length != 16 && length != 24 && length != 32 {
-		fmt.Printf("BCEX Key must be 16, 24 or 32 bytes, current is %d\n", length)
-		os.Exit(1)
+// FieldNames lists the names of fields, optional ones in brackets and
+// named ones as NAME=VALUE
+func FieldNames(fields []CredentialField) string {
+	var names []string
+	for _, f := range fields {
+		n := f.Name
+		if f.Named {
+			n += "=VALUE"
+		}
+		if f.Optional {
+			n = "[" + n + "]"
+		}
+		names = append(names, n)
 	}
+	return strings.Join(names, " ")
+}
 
-	ReadConf()
+// store of the keys of accounts, an account is the exchange name or
+// EX/PROFILE, like binance/main, for more accounts on one exchange
+var store KeyStore
 
+// ExName returns the exchange name of account
+func ExName(account string) string {
+	return strings.SplitN(account, "/", 2)[0]
 }
 
-func WriteConf(name, accesskey, secretkey string) {
-	var exKey ExchangeKey
+// Store opens the key store on its first use, so commands on public
+// market data run without BCEX Key.
+func Store() (KeyStore, error) {
+	if store != nil {
+		return store, nil
+	}
+	s, err := OpenStore(*storeKind)
+	if err != nil {
+		code := ExitConfig
+		if errors.Is(err, ErrWrongPassphrase) {
+			code = ExitAuth
+		}
+		return nil, &Error{code, fmt.Errorf("Open key store: %v", err)}
+	}
+	store = s
+	return store, nil
+}
 
-	ex := GetEx(name)
-	if ex == nil {
-		fmt.Println("Exchange: (", name, ") is not found, use command list to show supported exchagnes name")
-		return
+func WriteConf(name string, values []string) error {
+	ex := GetEx(ExName(name))
+	if ex == nil || strings.HasSuffix(name, "/") {
+		return &Error{ExitNotSupported, fmt.Errorf("Exchange %s is not found, use command list to show supported exchagnes name", name)}
 	}
 
-	if keys == nil {
-		keys = make(map[string]ExchangeKey)
+	c, err := ParseCredentials(ExName(name), values)
+	if err != nil {
+		return &Error{ExitUsage, err}
 	}
-	exKey.AccessKeyId = accesskey
-	exKey.SecretKeyId = secretkey
-	keys[name] = exKey
 
-	plain, _ := json.Marshal(keys)
-	raw := Encrypt(string(plain), *bcexKey)
-	ioutil.WriteFile("config.json", []byte(raw), 0644)
+	store, err := Store()
+	if err != nil {
+		return err
+	}
+	if err := store.Set(name, c); err != nil {
+		return &Error{ExitConfig, fmt.Errorf("Write key store: %v", err)}
+	}
+	return nil
 }
 
-func ReadConf() {
-	raw, err := ioutil.ReadFile("config.json")
+// RemoveKey drops the keys of account name from the key store
+func RemoveKey(name string) error {
+	store, err := Store()
 	if err != nil {
-		return
+		return err
+	}
+	err = store.Remove(name)
+	if err == ErrNoKey {
+		err = fmt.Errorf("No key of %s", name)
 	}
+	return err
+}
 
-	plain := Decrypt(string(raw), *bcexKey)
+// Rekey encrypts the key store again with newkey as BCEX Key
+func Rekey(newkey string) error {
+	store, err := Store()
+	if err != nil {
+		return err
+	}
+	fs, ok := store.(*fileStore)
+	if !ok {
+		return errors.New("Only the file key store is encrypted by BCEX Key")
+	}
+	return fs.Rekey(newkey)
+}
 
-	json.Unmarshal([]byte(plain), &keys)
+// KeyNames returns the sorted names of accounts with keys
+func KeyNames() ([]string, error) {
+	store, err := Store()
+	if err != nil {
+		return nil, err
+	}
+	return store.List()
+}
+
+// MaskKey hides all but the head and tail of key
+func MaskKey(key string) string {
+	if len(key) < 12 {
+		return strings.Repeat("*", len(key))
+	}
+	return key[:4] + strings.Repeat("*", len(key)-8) + key[len(key)-4:]
+}
+
+// GetKey returns the credentials of account name
+func GetKey(name string) (Credentials, error) {
+	store, err := Store()
+	if err != nil {
+		return nil, err
+	}
+	return store.Get(name)
+}
+
+// SetKey gives ex the credentials configured for account name, for the
+// commands sending signed requests; the public ones don't call it.
+// Simulated exchanges need none.
+func SetKey(ex Exchange, name string) error {
+	if ex.Capabilities().HasFeature(Simulated) {
+		return nil
+	}
+	c, err := GetKey(name)
+	if err == ErrNoKey {
+		return &Error{ExitAuth, fmt.Errorf("No key of %s, run bcex setkey %s first", name, name)}
+	}
+	if err != nil {
+		return err
+	}
+	return ApplyCredentials(ex, c)
 }
 
 // CLI struct for main
@@ -75,6 +192,33 @@ type CLI struct {
 // NewCLI initializes new command line interface
 func NewCLI() *CLI {
 	c := &CLI{cli.App("bcex", "A BlockChain Exchange CLI")}
+	c.ErrorHandling = flag.ContinueOnError
+
+	configPath = c.String(cli.StringOpt{
+		Name:   "c config",
+		Desc:   "Path of the key store, $XDG_CONFIG_HOME/bcex/config.json by default",
+		EnvVar: "BCEX_CONFIG",
+	})
+
+	storeKind = c.String(cli.StringOpt{
+		Name:   "store",
+		Value:  "file",
+		Desc:   "Where the keys are kept: file, env, command or vault",
+		EnvVar: "BCEX_STORE",
+	})
+
+	keyCommand = c.String(cli.StringOpt{
+		Name:   "key-command",
+		Desc:   "Command printing a key for the command store, like \"pass show bcex/{account}/{field}\"",
+		EnvVar: "BCEX_KEY_COMMAND",
+	})
+
+	outputFormat = c.String(cli.StringOpt{
+		Name:   "o output",
+		Value:  "table",
+		Desc:   "Output format: table, json, jsonl or csv",
+		EnvVar: "BCEX_OUTPUT",
+	})
 
 	bcexKey = c.String(cli.StringOpt{
 		Name:      "k bcex-key",
@@ -85,3 +229,12 @@ func NewCLI() *CLI {
 
 	return c
 }
+
+// Exec runs the command in args and returns the exit code, see ExitCode
+func (c *CLI) Exec(args []string) int {
+	exitErr = nil
+	if err := c.Run(args); err != nil {
+		return ExitUsage
+	}
+	return ExitCode(exitErr)
+}
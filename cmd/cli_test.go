@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	. "github.com/RichardWeiYang/bcex/lib"
+)
+
+func TestParseCredentials(t *testing.T) {
+	cases := []struct {
+		name   string
+		values []string
+		want   Credentials // nil for an error
+	}{
+		{"binance", []string{"a", "s"}, Credentials{CredAccess: "a", CredSecret: "s"}},
+		{"binance", []string{"secret=s", "a"}, Credentials{CredAccess: "a", CredSecret: "s"}},
+		{"binance", []string{"a"}, nil},
+		{"bitstamp", []string{"a", "s", "c"}, Credentials{CredAccess: "a", CredSecret: "s", CredCustomerId: "c"}},
+		{"bigone", []string{"a"}, Credentials{CredAccess: "a"}},
+		{"bigone", []string{"a", "device-id=d"}, Credentials{CredAccess: "a", CredDeviceId: "d"}},
+		// the secret of an old setkey is not taken as the device id
+		{"bigone", []string{"a", "s"}, nil},
+		{"nosuchex", []string{"a", "s"}, nil},
+	}
+	for _, c := range cases {
+		got, err := ParseCredentials(c.name, c.values)
+		if c.want == nil && err == nil || c.want != nil && (err != nil || !reflect.DeepEqual(got, c.want)) {
+			t.Errorf("ParseCredentials(%s, %v) = %v, %v, want %v", c.name, c.values, got, err, c.want)
+		}
+	}
+}
+
+func TestSetKey(t *testing.T) {
+	defer func() { store = nil }()
+	store = &fileStore{path: filepath.Join(t.TempDir(), "config.json"), passphrase: "key"}
+	if err := store.Set("bitstamp", Credentials{CredAccess: "a", CredSecret: "s", CredCustomerId: "c"}); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		account string
+		want    int
+	}{
+		{"bitstamp", ExitOK},
+		{"binance", ExitAuth},
+		{"binance/mm", ExitAuth},
+		{"paper:binance", ExitOK},
+	}
+	for _, c := range cases {
+		err := SetKey(GetEx(ExName(c.account)), c.account)
+		if ExitCode(err) != c.want {
+			t.Errorf("SetKey of %s = %v, want exit code %d", c.account, err, c.want)
+		}
+	}
+}
@@ -1,7 +1,7 @@
 package cmd
 
 import (
-	"fmt"
+	"math"
 	"sort"
 	"strconv"
 	"strings"
@@ -10,67 +10,248 @@ import (
 	"github.com/jawher/mow.cli"
 )
 
-func min(a, b int) int {
-	if a > b {
-		return b
+// action runs f unless the output format is wrong
+func action(f func()) func() {
+	return func() {
+		if err := checkFormat(); err != nil {
+			report(err)
+			return
+		}
+		f()
 	}
-	return a
 }
 
 func (c *CLI) RegisterCommands() {
 	// list
-	c.Command("list", "List Exchanges", func(cmd *cli.Cmd) {
-		cmd.Action = func() {
-			lists := ListEx()
+	c.Command("list", "List Exchanges and their capabilities", func(cmd *cli.Cmd) {
+		var (
+			names   = cmd.BoolOpt("n names", false, "Only list the names")
+			support = cmd.StringsOpt("s support", nil, "Only list exchanges supporting the operation, e.g. neworder")
+		)
+
+		cmd.Action = action(func() {
+			lists := FindEx(*support...)
+			if *names {
+				t := NewTable("exchange")
+				for _, ex := range lists {
+					t.Add(ex)
+				}
+				output(t)
+				return
+			}
+
+			t := NewTable(append(append([]string{"exchange"}, AllOperations...),
+				"order_types", "features")...)
 			for _, ex := range lists {
-				fmt.Println(ex)
+				caps := GetEx(ex).Capabilities()
+				row := []string{ex}
+				for _, op := range AllOperations {
+					if caps.Has(op) {
+						row = append(row, "x")
+					} else {
+						row = append(row, "-")
+					}
+				}
+				t.Add(append(row, strings.Join(caps.OrderTypes, ","),
+					strings.Join(caps.Features, ","))...)
 			}
-		}
+			output(t)
+		})
 	})
 
 	c.Command("setkey", "Set Exchange API-KEY", func(cmd *cli.Cmd) {
+		cmd.Spec = "EX KEY..."
 		var (
-			exname    = cmd.StringArg("EX", "", "The Exchange to set")
-			accesskey = cmd.StringArg("AK", "", "The Exchange to set")
-			secretkey = cmd.StringArg("SK", "", "The Exchange to set")
+			exname = cmd.StringArg("EX", "", "The Exchange to set, or EX/PROFILE for one more account on it")
+			values = cmd.StringsArg("KEY", nil, "The credentials in order, or as FIELD=VALUE, see keys fields")
 		)
 
-		cmd.Action = func() {
-			Init(*bcexKey)
-			WriteConf(*exname, *accesskey, *secretkey)
-		}
+		cmd.Action = action(func() {
+			if err := WriteConf(*exname, *values); err != nil {
+				report(err)
+			} else {
+				done()
+			}
+		})
+	})
+
+	c.Command("keys", "Manage the keys in the key store", func(cmd *cli.Cmd) {
+		cmd.Command("list", "List accounts with keys, access keys are masked", func(cmd *cli.Cmd) {
+			cmd.Action = action(func() {
+				names, err := KeyNames()
+				if err != nil {
+					report(err)
+					return
+				}
+				t := NewTable("account", "access_key", "other_credentials")
+				for _, n := range names {
+					c, err := GetKey(n)
+					if err != nil {
+						reportOn(n, err)
+						continue
+					}
+					var other []string
+					for f := range c {
+						if f != CredAccess && f != CredSecret {
+							other = append(other, f)
+						}
+					}
+					sort.Strings(other)
+					if len(other) == 0 {
+						other = []string{"-"}
+					}
+					t.Add(n, MaskKey(c[CredAccess]), strings.Join(other, ","))
+				}
+				output(t)
+			})
+		})
+
+		cmd.Command("fields", "Show the credentials an exchange needs", func(cmd *cli.Cmd) {
+			exname := cmd.StringArg("EX", "", "The Exchange to show")
+
+			cmd.Action = action(func() {
+				fields := CredentialsOf(*exname)
+				if fields == nil {
+					report(notSupported(*exname))
+					return
+				}
+				t := NewTable("field", "optional", "desc")
+				for _, f := range fields {
+					t.Add(f.Name, strconv.FormatBool(f.Optional), f.Desc)
+				}
+				output(t)
+			})
+		})
+
+		cmd.Command("remove", "Remove the keys of an account", func(cmd *cli.Cmd) {
+			exname := cmd.StringArg("EX", "", "The Exchange or account(EX/PROFILE) to remove")
+
+			cmd.Action = action(func() {
+				if err := RemoveKey(*exname); err != nil {
+					report(err)
+				} else {
+					done()
+				}
+			})
+		})
+
+		cmd.Command("rekey", "Encrypt the key store with a new BCEX Key", func(cmd *cli.Cmd) {
+			newKey := cmd.String(cli.StringOpt{
+				Name:      "n new-key",
+				Desc:      "The new BCEX Key",
+				EnvVar:    "BCEX_NEW_KEY",
+				HideValue: true,
+			})
+
+			cmd.Action = action(func() {
+				if err := Rekey(*newKey); err != nil {
+					report(err)
+				} else {
+					done()
+				}
+			})
+		})
+
+		cmd.Command("verify", "Check the keys work by getting the balance", func(cmd *cli.Cmd) {
+			cmd.Spec = "[EX]"
+			exname := cmd.StringArg("EX", "all", "The Exchange or account(EX/PROFILE) to verify")
+
+			cmd.Action = action(func() {
+				exchanges := []string{*exname}
+				if *exname == "all" {
+					var err error
+					if exchanges, err = KeyNames(); err != nil {
+						report(err)
+						return
+					}
+				}
+				t := NewTable("account", "status")
+				for _, n := range exchanges {
+					ex := GetEx(ExName(n))
+					if ex == nil {
+						report(notSupported(n))
+						continue
+					}
+					err := SetKey(ex, n)
+					if err == nil {
+						_, err = ex.GetBalance()
+					}
+					if err != nil {
+						reportOn(n, err)
+						t.Add(n, "failed")
+					} else {
+						t.Add(n, "ok")
+					}
+				}
+				output(t)
+			})
+		})
+	})
+
+	c.Command("paperdeposit", "Deposit to a paper trading account", func(cmd *cli.Cmd) {
+		var (
+			exname   = cmd.StringArg("EX", "binance", "The Exchange to paper trade on")
+			currency = cmd.StringArg("CU", "usdt", "Currency to deposit(lower case)")
+			amount   = cmd.StringArg("AM", "1000", "The amount to deposit")
+		)
+
+		cmd.Action = action(func() {
+			ex := GetEx("paper:" + strings.TrimPrefix(*exname, "paper:"))
+			if ex == nil {
+				report(notSupported(*exname))
+				return
+			}
+
+			amount_f, err := strconv.ParseFloat(*amount, 64)
+			if err != nil || !(amount_f > 0) || math.IsInf(amount_f, 1) {
+				report(usageError("Amount %s is not a positive number", *amount))
+				return
+			}
+			err = ex.(*Paper).Deposit(*currency, amount_f)
+			if err != nil {
+				report(err)
+			} else {
+				done()
+			}
+		})
 	})
 
 	c.Command("balance", "Get Account Balance", func(cmd *cli.Cmd) {
 		var (
-			exname = cmd.StringArg("EX", "all", "The Exchange Name to display")
+			exname = cmd.StringArg("EX", "all", "The Exchange or account(EX/PROFILE) to display, all for every account")
 		)
 
-		cmd.Action = func() {
-			Init(*bcexKey)
-			exchanges := ListEx()
+		cmd.Action = action(func() {
+			exchanges := []string{*exname}
+			if *exname == "all" {
+				var err error
+				if exchanges, err = KeyNames(); err != nil {
+					report(err)
+					return
+				}
+			}
+			t := NewTable("account", "currency", "balance")
 			for _, n := range exchanges {
-				if *exname != "all" && n != *exname {
+				ex := GetEx(ExName(n))
+				if ex == nil {
+					report(notSupported(n))
+					continue
+				}
+				if err := SetKey(ex, n); err != nil {
+					reportOn(n, err)
 					continue
 				}
-				ex := GetEx(n)
-				ek := keys[n]
-				ex.SetKey(ek.AccessKeyId, ek.SecretKeyId)
 				balances, err := ex.GetBalance()
-				fmt.Println(n + ":")
-				if err == nil {
-					if len(balances) == 0 {
-						fmt.Println("\tNone")
-						continue
-					}
-					for _, b := range balances {
-						fmt.Println("\t", b.Currency, b.Balance)
-					}
-				} else {
-					fmt.Println("\tError:" + err.Error())
+				if err != nil {
+					reportOn(n, err)
+					continue
+				}
+				for _, b := range balances {
+					t.Add(n, b.Currency, b.Balance)
 				}
 			}
-		}
+			output(t)
+		})
 	})
 
 	c.Command("price", "Get current price", func(cmd *cli.Cmd) {
@@ -79,24 +260,23 @@ func (c *CLI) RegisterCommands() {
 			currencypair = cmd.StringArg("CP", "btc_usd", "CurrencyPair to query(lower case)")
 		)
 
-		cmd.Action = func() {
-			Init(*bcexKey)
-			ex := GetEx(*exname)
+		cmd.Action = action(func() {
+			ex := GetEx(ExName(*exname))
 			if ex == nil {
-				fmt.Println(*exname, ": not supported")
+				report(notSupported(*exname))
 				return
 			}
 
-			ek := keys[*exname]
-			ex.SetKey(ek.AccessKeyId, ek.SecretKeyId)
 			cp := NewCurrencyPair2(*currencypair)
 			price, err := ex.GetPrice(&cp)
 			if err != nil {
-				fmt.Println("Error: ", err)
+				report(err)
 			} else {
-				fmt.Printf("%0.8f\n", price.Price)
+				t := NewTable("price")
+				t.Add(decimal(price.Price))
+				output(t)
 			}
-		}
+		})
 	})
 
 	c.Command("symbols", "Get supported symbols", func(cmd *cli.Cmd) {
@@ -104,24 +284,24 @@ func (c *CLI) RegisterCommands() {
 			exname = cmd.StringArg("EX", "bigone", "The Exchange to query")
 		)
 
-		cmd.Action = func() {
-			Init(*bcexKey)
-			ex := GetEx(*exname)
+		cmd.Action = action(func() {
+			ex := GetEx(ExName(*exname))
 			if ex == nil {
-				fmt.Println(*exname, ": not supported")
+				report(notSupported(*exname))
 				return
 			}
 
 			symbols, err := ex.GetSymbols()
 			if err != nil {
-				fmt.Println("Error: ", err)
+				report(err)
 			} else {
+				t := NewTable("symbol")
 				for _, s := range symbols {
-					fmt.Printf("%s ", s)
+					t.Add(s)
 				}
-				fmt.Println()
+				output(t)
 			}
-		}
+		})
 	})
 
 	c.Command("coins", "Get supported coins", func(cmd *cli.Cmd) {
@@ -129,17 +309,16 @@ func (c *CLI) RegisterCommands() {
 			exname = cmd.StringArg("EX", "bigone", "The Exchange to query")
 		)
 
-		cmd.Action = func() {
-			Init(*bcexKey)
-			ex := GetEx(*exname)
+		cmd.Action = action(func() {
+			ex := GetEx(ExName(*exname))
 			if ex == nil {
-				fmt.Println(*exname, ": not supported")
+				report(notSupported(*exname))
 				return
 			}
 
 			symbols, err := ex.GetSymbols()
 			if err != nil {
-				fmt.Println("Error: ", err)
+				report(err)
 			} else {
 				var coins map[string]int
 				coins = make(map[string]int)
@@ -155,49 +334,165 @@ func (c *CLI) RegisterCommands() {
 				sort.Slice(oc, func(i, j int) bool {
 					return oc[i] < oc[j]
 				})
+				t := NewTable("coin")
 				for _, c := range oc {
-					fmt.Printf("%s ", c)
+					t.Add(c)
 				}
-				fmt.Println()
+				output(t)
 			}
-		}
+		})
 	})
 
 	c.Command("depth", "Get depth for currency pair", func(cmd *cli.Cmd) {
 		var (
+			levels       = cmd.IntOpt("l levels", 5, "Levels of each side to show")
+			group        = cmd.StringOpt("g group", "", "Group levels into price buckets, a step like 0.1 or bps of the mid price like 10bps")
+			summary      = cmd.BoolOpt("s summary", false, "Only show the best prices, spread and mid price")
+			chart        = cmd.BoolOpt("c chart", false, "Draw the cumulative amount of each level")
 			exname       = cmd.StringArg("EX", "bigone", "The Exchange to query")
 			currencypair = cmd.StringArg("CP", "btc_usd", "CurrencyPair to query(lower case)")
 		)
 
-		cmd.Action = func() {
-			Init(*bcexKey)
-			ex := GetEx(*exname)
+		cmd.Action = action(func() {
+			ex := GetEx(ExName(*exname))
 			if ex == nil {
-				fmt.Println(*exname, ": not supported")
+				report(notSupported(*exname))
+				return
+			}
+
+			if *levels <= 0 {
+				report(usageError("Levels must be positive"))
+				return
+			}
+			if *chart && format() != "table" {
+				report(usageError("Chart is only drawn in table output"))
 				return
 			}
 
 			cp := NewCurrencyPair2(*currencypair)
 			depth, err := ex.GetDepth(&cp)
 			if err != nil {
-				fmt.Println("Error: ", err)
+				report(err)
+				return
+			}
+			if *summary {
+				output(summaryTable(depth))
+				return
+			}
+
+			step, err := parseGroup(*group, depth.Mid())
+			if err != nil {
+				report(err)
+				return
+			}
+			shown := depth.Group(step).Top(*levels)
+			if *chart {
+				printChart(shown)
+			} else {
+				output(depthTable(shown))
+			}
+			if format() == "table" {
+				printSummary(depth)
+			}
+		})
+	})
+
+	c.Command("ticker", "Get ticker for currency pair", func(cmd *cli.Cmd) {
+		var (
+			exname       = cmd.StringArg("EX", "otcbtc", "The Exchange to query")
+			currencypair = cmd.StringArg("CP", "btc_usdt", "CurrencyPair to query(lower case)")
+		)
+
+		cmd.Action = action(func() {
+			ex := GetEx(ExName(*exname))
+			if ex == nil {
+				report(notSupported(*exname))
+				return
+			}
+			md, ok := ex.(MarketData)
+			if !ok {
+				report(ErrNotImplemented)
+				return
+			}
+
+			cp := NewCurrencyPair2(*currencypair)
+			ticker, err := md.GetTicker(&cp)
+			if err != nil {
+				report(err)
+			} else {
+				t := NewTable("last", "buy", "sell", "high", "low", "volume", "time")
+				t.Add(decimal(ticker.Last), decimal(ticker.Buy), decimal(ticker.Sell),
+					decimal(ticker.High), decimal(ticker.Low), decimal(ticker.Volume),
+					timestamp(ticker.Time))
+				output(t)
+			}
+		})
+	})
+
+	c.Command("trades", "Get recent trades for currency pair", func(cmd *cli.Cmd) {
+		var (
+			exname       = cmd.StringArg("EX", "otcbtc", "The Exchange to query")
+			currencypair = cmd.StringArg("CP", "btc_usdt", "CurrencyPair to query(lower case)")
+		)
+
+		cmd.Action = action(func() {
+			ex := GetEx(ExName(*exname))
+			if ex == nil {
+				report(notSupported(*exname))
+				return
+			}
+			md, ok := ex.(MarketData)
+			if !ok {
+				report(ErrNotImplemented)
+				return
+			}
+
+			cp := NewCurrencyPair2(*currencypair)
+			trades, err := md.GetTrades(&cp)
+			if err != nil {
+				report(err)
 			} else {
-				fmt.Println("Depth of ", *currencypair, "on ", *exname)
-				fmt.Println("\tPrice      \tAmount")
-				fmt.Println("Asks:")
-				for i := min(5, len(depth.Asks)); i >= 1; i-- {
-					fmt.Printf("\t%0.8f\t%0.8f\n",
-						depth.Asks[len(depth.Asks)-i].Price,
-						depth.Asks[len(depth.Asks)-i].Amount)
+				t := NewTable("time", "side", "price", "amount")
+				for _, tr := range trades {
+					t.Add(timestamp(tr.Time), tr.Side, decimal(tr.Price), decimal(tr.Amount))
 				}
-				fmt.Println("Bids:")
-				for i := 0; i < min(5, len(depth.Bids)); i++ {
-					fmt.Printf("\t%0.8f\t%0.8f\n",
-						depth.Bids[i].Price,
-						depth.Bids[i].Amount)
+				output(t)
+			}
+		})
+	})
+
+	c.Command("kline", "Get klines for currency pair", func(cmd *cli.Cmd) {
+		var (
+			period       = cmd.StringOpt("p period", "1h", "1m, 5m, 15m, 30m, 1h, 2h, 4h, 6h, 12h, 1d or 1w")
+			exname       = cmd.StringArg("EX", "otcbtc", "The Exchange to query")
+			currencypair = cmd.StringArg("CP", "btc_usdt", "CurrencyPair to query(lower case)")
+		)
+
+		cmd.Action = action(func() {
+			ex := GetEx(ExName(*exname))
+			if ex == nil {
+				report(notSupported(*exname))
+				return
+			}
+			md, ok := ex.(MarketData)
+			if !ok {
+				report(ErrNotImplemented)
+				return
+			}
+
+			cp := NewCurrencyPair2(*currencypair)
+			klines, err := md.GetKlines(&cp, *period)
+			if err != nil {
+				report(err)
+			} else {
+				t := NewTable("time", "open", "high", "low", "close", "volume")
+				for _, k := range klines {
+					t.Add(timestamp(k.Time), decimal(k.Open), decimal(k.High),
+						decimal(k.Low), decimal(k.Close), decimal(k.Volume))
 				}
+				output(t)
 			}
-		}
+		})
 	})
 
 	c.Command("neworder", "place an order", func(cmd *cli.Cmd) {
@@ -209,20 +504,29 @@ func (c *CLI) RegisterCommands() {
 			amount       = cmd.StringArg("AM", "0.2", "The amount you want to buy or sel")
 		)
 
-		cmd.Action = func() {
-			Init(*bcexKey)
-			ex := GetEx(*exname)
+		cmd.Action = action(func() {
+			ex := GetEx(ExName(*exname))
 			if ex == nil {
-				fmt.Println(*exname, ": not supported")
+				report(notSupported(*exname))
 				return
 			}
 
-			ek := keys[*exname]
-			ex.SetKey(ek.AccessKeyId, ek.SecretKeyId)
+			if err := SetKey(ex, *exname); err != nil {
+				report(err)
+				return
+			}
 
 			cp := NewCurrencyPair2(*currencypair)
-			price_f, _ := strconv.ParseFloat(*price, 64)
-			amount_f, _ := strconv.ParseFloat(*amount, 64)
+			price_f, err := strconv.ParseFloat(*price, 64)
+			if err != nil {
+				report(usageError("Price %s is not a number", *price))
+				return
+			}
+			amount_f, err := strconv.ParseFloat(*amount, 64)
+			if err != nil {
+				report(usageError("Amount %s is not a number", *amount))
+				return
+			}
 			order := Order{
 				CP:     cp,
 				Side:   *side,
@@ -232,11 +536,13 @@ func (c *CLI) RegisterCommands() {
 
 			id, err := ex.NewOrder(&order)
 			if err != nil {
-				fmt.Println("Error: ", err)
+				report(err)
 			} else {
-				fmt.Println("ID:      ", id)
+				t := NewTable("id")
+				t.Add(id)
+				output(t)
 			}
-		}
+		})
 	})
 
 	c.Command("cancelorder", "cancel an order", func(cmd *cli.Cmd) {
@@ -246,25 +552,26 @@ func (c *CLI) RegisterCommands() {
 			id     = cmd.StringArg("ID", "id", "order id")
 		)
 
-		cmd.Action = func() {
-			Init(*bcexKey)
-			ex := GetEx(*exname)
+		cmd.Action = action(func() {
+			ex := GetEx(ExName(*exname))
 			if ex == nil {
-				fmt.Println(*exname, ": not supported")
+				report(notSupported(*exname))
 				return
 			}
 
-			ek := keys[*exname]
-			ex.SetKey(ek.AccessKeyId, ek.SecretKeyId)
+			if err := SetKey(ex, *exname); err != nil {
+				report(err)
+				return
+			}
 
 			o := Order{Id: *id, CP: NewCurrencyPair2(*symbol)}
 			err := ex.CancelOrder(&o)
 			if err != nil {
-				fmt.Println("Error:", err)
+				report(err)
 			} else {
-				fmt.Println("Done")
+				done()
 			}
-		}
+		})
 	})
 
 	c.Command("queryorder", "query an order", func(cmd *cli.Cmd) {
@@ -274,31 +581,28 @@ func (c *CLI) RegisterCommands() {
 			id     = cmd.StringArg("ID", "id", "order id")
 		)
 
-		cmd.Action = func() {
-			Init(*bcexKey)
-			ex := GetEx(*exname)
+		cmd.Action = action(func() {
+			ex := GetEx(ExName(*exname))
 			if ex == nil {
-				fmt.Println(*exname, ": not supported")
+				report(notSupported(*exname))
 				return
 			}
 
-			ek := keys[*exname]
-			ex.SetKey(ek.AccessKeyId, ek.SecretKeyId)
+			if err := SetKey(ex, *exname); err != nil {
+				report(err)
+				return
+			}
 
 			order := Order{Id: *id, CP: NewCurrencyPair2(*symbol)}
 			o, err := ex.QueryOrder(&order)
 			if err != nil {
-				fmt.Println("Error:", err)
+				report(err)
 			} else {
-				fmt.Println("ID:      ", o.Id)
-				fmt.Println("Symbol:  ", o.CP.String())
-				fmt.Println("Side:    ", o.Side)
-				fmt.Printf("Price:    %0.8f\n", o.Price)
-				fmt.Printf("Amount:   %0.8f\n", o.Amount)
-				fmt.Printf("Executed: %0.8f\n", o.Executed)
-				fmt.Printf("Remain:   %0.8f\n", o.Remain)
-				fmt.Println("State:   ", o.State)
+				t := NewTable("id", "symbol", "side", "price", "amount", "executed", "remain", "state")
+				t.Add(o.Id, o.CP.String(), o.Side, decimal(o.Price), decimal(o.Amount),
+					decimal(o.Executed), decimal(o.Remain), o.State)
+				output(t)
 			}
-		}
+		})
 	})
 }
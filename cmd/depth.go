@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	. "github.com/RichardWeiYang/bcex/lib"
+)
+
+// parseGroup returns the bucket size of group, a price step like 0.1, or
+// basis points of the mid price like 10bps
+func parseGroup(group string, mid float64) (float64, error) {
+	if group == "" {
+		return 0, nil
+	}
+	bps := strings.HasSuffix(group, "bps")
+	step, err := strconv.ParseFloat(strings.TrimSuffix(group, "bps"), 64)
+	if err != nil || step < 0 {
+		return 0, usageError("Group %s is neither a price step nor bps", group)
+	}
+	if bps && mid > 0 && step > 0 {
+		// two significant digits, so buckets are at round prices
+		step = mid * step / 10000
+		p := math.Pow(10, math.Floor(math.Log10(step))-1)
+		step, _ = strconv.ParseFloat(strconv.FormatFloat(math.Round(step/p)*p, 'g', 2, 64), 64)
+	}
+	return step, nil
+}
+
+// depthTable lists asks from the highest down to the bids, each with the
+// cumulative amount and notional from the best price
+func depthTable(d Depth) *Table {
+	t := NewTable("side", "price", "amount", "cumulative", "notional", "cumulative_notional")
+	amount, notional := Cumulative(d.Asks)
+	for i := len(d.Asks) - 1; i >= 0; i-- {
+		u := d.Asks[i]
+		t.Add("ask", decimal(u.Price), decimal(u.Amount), decimal(amount[i]),
+			decimal(u.Price*u.Amount), decimal(notional[i]))
+	}
+	amount, notional = Cumulative(d.Bids)
+	for i, u := range d.Bids {
+		t.Add("bid", decimal(u.Price), decimal(u.Amount), decimal(amount[i]),
+			decimal(u.Price*u.Amount), decimal(notional[i]))
+	}
+	return t
+}
+
+func summaryTable(d Depth) *Table {
+	t := NewTable("best_ask", "best_bid", "spread", "spread_bps", "mid")
+	if len(d.Asks) == 0 || len(d.Bids) == 0 {
+		return t
+	}
+	t.Add(decimal(d.Asks[0].Price), decimal(d.Bids[0].Price), decimal(d.Spread()),
+		strconv.FormatFloat(d.Spread()/d.Mid()*10000, 'f', 2, 64), decimal(d.Mid()))
+	return t
+}
+
+// printSummary prints the spread and mid price below a depth table
+func printSummary(d Depth) {
+	if len(d.Asks) == 0 || len(d.Bids) == 0 {
+		return
+	}
+	fmt.Printf("\nspread %s (%.2f bps), mid %s\n", decimal(d.Spread()),
+		d.Spread()/d.Mid()*10000, decimal(d.Mid()))
+}
+
+const chartWidth = 50
+
+// printChart draws the cumulative amount of each level as a bar, # for
+// asks and = for bids
+func printChart(d Depth) {
+	askAmount, _ := Cumulative(d.Asks)
+	bidAmount, _ := Cumulative(d.Bids)
+	max := 0.0
+	for _, a := range append(askAmount, bidAmount...) {
+		max = math.Max(max, a)
+	}
+	bar := func(c string, amount float64) string {
+		if max == 0 {
+			return ""
+		}
+		return strings.Repeat(c, int(math.Ceil(amount/max*chartWidth)))
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	for i := len(d.Asks) - 1; i >= 0; i-- {
+		fmt.Fprintf(w, "%s\t%s\t %s\n", decimal(d.Asks[i].Price), decimal(askAmount[i]),
+			bar("#", askAmount[i]))
+	}
+	for i, u := range d.Bids {
+		fmt.Fprintf(w, "%s\t%s\t %s\n", decimal(u.Price), decimal(bidAmount[i]),
+			bar("=", bidAmount[i]))
+	}
+	w.Flush()
+}
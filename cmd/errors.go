@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	. "github.com/RichardWeiYang/bcex/lib"
+)
+
+// Exit codes of bcex, by the class of the first failure
+const (
+	ExitOK           = 0
+	ExitError        = 1 // the exchange refused or failed the request
+	ExitUsage        = 2 // wrong arguments
+	ExitConfig       = 3 // the key store could not be opened, read or written
+	ExitAuth         = 4 // wrong BCEX Key, or no key of the account
+	ExitNotSupported = 5 // unknown exchange, or an operation it doesn't support
+	ExitNetwork      = 6 // the exchange could not be reached
+)
+
+// Error is a failure of a command with its exit code
+type Error struct {
+	Code int
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func usageError(format string, a ...interface{}) error {
+	return &Error{ExitUsage, fmt.Errorf(format, a...)}
+}
+
+func notSupported(name string) error {
+	return &Error{ExitNotSupported, fmt.Errorf("%s: not supported", name)}
+}
+
+// ExitCode returns the exit code for err
+func ExitCode(err error) int {
+	var e *Error
+	var ne net.Error
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.As(err, &e):
+		return e.Code
+	case errors.Is(err, ErrWrongPassphrase):
+		return ExitAuth
+	case errors.Is(err, ErrNotImplemented):
+		return ExitNotSupported
+	case errors.As(err, &ne):
+		return ExitNetwork
+	}
+	return ExitError
+}
+
+// exitErr is the first failure of the command run
+var exitErr error
+
+// keep keeps the first failure for the exit code
+func keep(err error) {
+	if exitErr == nil {
+		exitErr = err
+	}
+}
+
+// report prints err and keeps it for the exit code
+func report(err error) {
+	printError(err)
+	keep(err)
+}
+
+// reportOn reports err of account name
+func reportOn(name string, err error) {
+	report(&Error{ExitCode(err), fmt.Errorf("%s: %v", name, err)})
+}
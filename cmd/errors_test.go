@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+
+	. "github.com/RichardWeiYang/bcex/lib"
+)
+
+func TestExitCode(t *testing.T) {
+	cases := []struct {
+		err  error
+		want int
+	}{
+		{nil, ExitOK},
+		{errors.New("Insufficient balance"), ExitError},
+		{usageError("Amount %s is not a number", "x"), ExitUsage},
+		{&Error{ExitConfig, errors.New("Write key store")}, ExitConfig},
+		{ErrWrongPassphrase, ExitAuth},
+		{fmt.Errorf("Open key store: %w", ErrWrongPassphrase), ExitAuth},
+		{notSupported("binance"), ExitNotSupported},
+		{ErrNotImplemented, ExitNotSupported},
+		{&net.DNSError{Err: "no such host", Name: "api.binance.com"}, ExitNetwork},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, ExitNetwork},
+		// the class survives the account name reportOn adds
+		{&Error{ExitCode(ErrNotImplemented), errors.New("binance: Not implemented")}, ExitNotSupported},
+	}
+	for _, c := range cases {
+		if code := ExitCode(c.err); code != c.want {
+			t.Errorf("ExitCode(%v) = %d, want %d", c.err, code, c.want)
+		}
+	}
+}
+
+func TestStoreExitCode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	fs := &fileStore{path: path, passphrase: "right"}
+	if err := fs.Set("binance", Credentials{CredAccess: "a", CredSecret: "s"}); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(k, c, s *string) {
+		bcexKey, configPath, storeKind = k, c, s
+		store = nil
+	}(bcexKey, configPath, storeKind)
+	configPath = &path
+
+	cases := []struct {
+		kind, key string
+		want      int
+	}{
+		{"file", "right", ExitOK},
+		{"file", "wrong", ExitAuth},
+		{"file", "", ExitConfig},
+		{"keyring", "right", ExitConfig},
+	}
+	for _, c := range cases {
+		kind, key := c.kind, c.key
+		storeKind, bcexKey = &kind, &key
+		store = nil
+		if _, err := Store(); ExitCode(err) != c.want {
+			t.Errorf("Store of %s with key %q = %v, want exit code %d", kind, key, err, c.want)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+/*
+ * Commands give their result as a Table, which is printed in the format of
+ * the --output option
+ *
+ *   table: aligned columns, a table of one field is printed without header
+ *   json:  an array of objects with the fields as keys
+ *   jsonl: one object a line
+ *   csv:   the fields as header
+ *
+ * Numbers are decimal strings, times are RFC 3339. Errors are printed to
+ * stderr, as an object with error, code and class in all but table.
+ */
+
+var outputFormat *string
+
+var outputFormats = []string{"table", "json", "jsonl", "csv"}
+
+type Table struct {
+	Fields []string
+	Rows   [][]string
+}
+
+func NewTable(fields ...string) *Table {
+	return &Table{Fields: fields}
+}
+
+// Add appends a row with values in the order of Fields
+func (t *Table) Add(values ...string) {
+	t.Rows = append(t.Rows, values)
+}
+
+// Objects returns the rows as objects with the fields as keys
+func (t *Table) Objects() []map[string]string {
+	objs := []map[string]string{}
+	for _, row := range t.Rows {
+		obj := map[string]string{}
+		for i, f := range t.Fields {
+			obj[f] = row[i]
+		}
+		objs = append(objs, obj)
+	}
+	return objs
+}
+
+func decimal(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func timestamp(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+func format() string {
+	if outputFormat == nil || *outputFormat == "" {
+		return "table"
+	}
+	return *outputFormat
+}
+
+func checkFormat() error {
+	for _, f := range outputFormats {
+		if format() == f {
+			return nil
+		}
+	}
+	return usageError("Unknown output %s, use %s", format(), strings.Join(outputFormats, ", "))
+}
+
+// output prints t in the output format
+func output(t *Table) {
+	switch format() {
+	case "json":
+		enc := newEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(t.Objects())
+	case "jsonl":
+		enc := newEncoder(os.Stdout)
+		for _, obj := range t.Objects() {
+			enc.Encode(obj)
+		}
+	case "csv":
+		w := csv.NewWriter(os.Stdout)
+		w.Write(t.Fields)
+		w.WriteAll(t.Rows)
+	default:
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		if len(t.Fields) > 1 {
+			fmt.Fprintln(w, strings.ToUpper(strings.Join(t.Fields, "\t")))
+		}
+		for _, row := range t.Rows {
+			fmt.Fprintln(w, strings.Join(row, "\t"))
+		}
+		w.Flush()
+	}
+}
+
+// done reports a command without result succeeded
+func done() {
+	t := NewTable("status")
+	t.Add("done")
+	output(t)
+}
+
+var exitClasses = map[int]string{
+	ExitError:        "error",
+	ExitUsage:        "usage",
+	ExitConfig:       "config",
+	ExitAuth:         "auth",
+	ExitNotSupported: "not-supported",
+	ExitNetwork:      "network",
+}
+
+// printError prints err to stderr in the output format
+func printError(err error) {
+	if format() == "table" || checkFormat() != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return
+	}
+	code := ExitCode(err)
+	newEncoder(os.Stderr).Encode(struct {
+		Error string `json:"error"`
+		Code  int    `json:"code"`
+		Class string `json:"class"`
+	}{err.Error(), code, exitClasses[code]})
+}
+
+func newEncoder(w io.Writer) *json.Encoder {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return enc
+}
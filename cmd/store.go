@@ -0,0 +1,386 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	. "github.com/RichardWeiYang/bcex/lib"
+)
+
+/*
+ * KeyStore keeps the credentials of accounts, an account is the exchange
+ * name or EX/PROFILE. The backends are
+ *
+ *   file:    the config.json encrypted by BCEX Key
+ *   env:     BCEX_<ACCOUNT>_<FIELD>, like BCEX_BINANCE_KEY and
+ *            BCEX_BINANCE_MAIN_SECRET for binance/main
+ *   command: a command printing one credential, like pass or gpg
+ *   vault:   the kv secrets engine of a HashiCorp Vault
+ *
+ * Only file and vault could be changed by bcex.
+ */
+type KeyStore interface {
+	Get(account string) (Credentials, error)
+	Set(account string, c Credentials) error
+	Remove(account string) error
+	List() ([]string, error)
+}
+
+var (
+	ErrNoKey    = errors.New("No key of the account")
+	ErrReadOnly = errors.New("The key store is read only")
+	ErrNoList   = errors.New("The key store could not list accounts")
+)
+
+// OpenStore opens key store kind
+func OpenStore(kind string) (KeyStore, error) {
+	switch kind {
+	case "", "file":
+		if len(*bcexKey) == 0 {
+			return nil, errors.New("BCEX Key is needed, use -k or ENV to set it")
+		}
+		fs := &fileStore{path: ConfPath(), passphrase: *bcexKey}
+		return fs, fs.load()
+	case "env":
+		return envStore{}, nil
+	case "command":
+		if len(*keyCommand) == 0 {
+			return nil, errors.New("Key command is needed, use --key-command or ENV to set it")
+		}
+		return commandStore{*keyCommand}, nil
+	case "vault":
+		return NewVaultStore(os.Getenv("VAULT_ADDR"), os.Getenv("VAULT_TOKEN")), nil
+	}
+	return nil, fmt.Errorf("Unknown key store %s, use file, env, command or vault", kind)
+}
+
+// ConfPath returns where the file store keeps the keys, the -c option or
+// $XDG_CONFIG_HOME/bcex/config.json by default
+func ConfPath() string {
+	if configPath != nil && *configPath != "" {
+		return *configPath
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "config.json"
+	}
+	return filepath.Join(dir, "bcex", "config.json")
+}
+
+// ExchangeKey is how the file store keeps the credentials of an account
+type ExchangeKey struct {
+	AccessKeyId string `json:"AccessKeyId"`
+	SecretKeyId string `json:"SecretKeyId"`
+	// other credentials by the field name
+	Extra map[string]string `json:"Extra,omitempty"`
+	// the third key saved before Extra, which is the first credential
+	// beside the access and secret key of the exchange
+	ExtraKeyId string `json:"ExtraKeyId,omitempty"`
+}
+
+func NewExchangeKey(c Credentials) (ek ExchangeKey) {
+	for n, v := range c {
+		switch n {
+		case CredAccess:
+			ek.AccessKeyId = v
+		case CredSecret:
+			ek.SecretKeyId = v
+		default:
+			if ek.Extra == nil {
+				ek.Extra = map[string]string{}
+			}
+			ek.Extra[n] = v
+		}
+	}
+	return
+}
+
+// Credentials returns the credentials of ek for account name
+func (ek ExchangeKey) Credentials(name string) Credentials {
+	c := Credentials{}
+	for n, v := range ek.Extra {
+		c[n] = v
+	}
+	if ek.AccessKeyId != "" {
+		c[CredAccess] = ek.AccessKeyId
+	}
+	if ek.SecretKeyId != "" {
+		c[CredSecret] = ek.SecretKeyId
+	}
+	if ek.ExtraKeyId != "" {
+		for _, f := range CredentialsOf(ExName(name)) {
+			if f.Name != CredAccess && f.Name != CredSecret && c[f.Name] == "" {
+				c[f.Name] = ek.ExtraKeyId
+				break
+			}
+		}
+	}
+	return c
+}
+
+type fileStore struct {
+	path, passphrase string
+	keys             map[string]ExchangeKey
+}
+
+func (fs *fileStore) Get(account string) (Credentials, error) {
+	ek, ok := fs.keys[account]
+	if !ok {
+		return nil, ErrNoKey
+	}
+	return ek.Credentials(account), nil
+}
+
+func (fs *fileStore) Set(account string, c Credentials) error {
+	if fs.keys == nil {
+		fs.keys = make(map[string]ExchangeKey)
+	}
+	fs.keys[account] = NewExchangeKey(c)
+	return fs.save()
+}
+
+func (fs *fileStore) Remove(account string) error {
+	if _, ok := fs.keys[account]; !ok {
+		return ErrNoKey
+	}
+	delete(fs.keys, account)
+	return fs.save()
+}
+
+func (fs *fileStore) List() ([]string, error) {
+	var names []string
+	for n := range fs.keys {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Rekey encrypts the file again with passphrase
+func (fs *fileStore) Rekey(passphrase string) error {
+	if len(passphrase) == 0 {
+		return errors.New("New BCEX Key is empty")
+	}
+	fs.passphrase = passphrase
+	return fs.save()
+}
+
+func (fs *fileStore) save() error {
+	plain, _ := json.Marshal(fs.keys)
+	raw, err := Encrypt(string(plain), fs.passphrase)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(fs.path, []byte(raw))
+}
+
+// load reads the keys from the file. A config.json in the current
+// directory, where older bcex kept it, is taken when there is none, and
+// one written in the old AES-CFB format is rewritten in the current one.
+// Either is written anew readable by the owner only, while a file in the
+// current format others could read is refused.
+func (fs *fileStore) load() error {
+	path := fs.path
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) && (configPath == nil || *configPath == "") {
+		if fi, err = os.Stat("config.json"); err == nil {
+			path = "config.json"
+		}
+	}
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	plain, err := Decrypt(string(raw), fs.passphrase)
+	cfb := err == ErrUnversioned
+	if !cfb && path == fs.path && fi.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("%s is accessible by its group or others, run chmod 600 on it", path)
+	}
+	if cfb {
+		// there is no integrity check in CFB, tell a wrong key by the json
+		plain, err = DecryptCFB(string(raw), fs.passphrase)
+		if err != nil || json.Unmarshal([]byte(plain), &fs.keys) != nil {
+			return ErrWrongPassphrase
+		}
+	} else if err != nil {
+		return err
+	} else if err = json.Unmarshal([]byte(plain), &fs.keys); err != nil {
+		return err
+	}
+
+	if path == fs.path {
+		if cfb {
+			return fs.save()
+		}
+		return nil
+	}
+	fmt.Fprintln(os.Stderr, "Moving", path, "to", fs.path)
+	if err = fs.save(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// writeFileAtomic replaces path with data readable by the owner only, path
+// has either the old or the new data whenever bcex stops.
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(dir, "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err = f.Chmod(0600); err == nil {
+		if _, err = f.Write(data); err == nil {
+			err = f.Sync()
+		}
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// fieldsOf returns the credential fields of account
+func fieldsOf(account string) ([]CredentialField, error) {
+	fields := CredentialsOf(ExName(account))
+	if fields == nil {
+		return nil, fmt.Errorf("Exchange %s is not found", ExName(account))
+	}
+	return fields, nil
+}
+
+// getFields collects the credentials of account from get, which returns
+// "" for a field not set.
+func getFields(account string, get func(field string) (string, error)) (Credentials, error) {
+	fields, err := fieldsOf(account)
+	if err != nil {
+		return nil, err
+	}
+
+	c := Credentials{}
+	for _, f := range fields {
+		v, err := get(f.Name)
+		if err != nil && !f.Optional {
+			return nil, err
+		}
+		if v != "" {
+			c[f.Name] = v
+		}
+	}
+	if len(c) == 0 {
+		return nil, ErrNoKey
+	}
+	return c, nil
+}
+
+type envStore struct{}
+
+// EnvName returns the environment variable of field of account, the
+// access key is BCEX_<ACCOUNT>_KEY
+func EnvName(account, field string) string {
+	switch field {
+	case CredAccess:
+		field = "key"
+	}
+	name := "BCEX_" + account + "_" + field
+	return strings.ToUpper(strings.NewReplacer("/", "_", "-", "_", ":", "_").Replace(name))
+}
+
+func (envStore) Get(account string) (Credentials, error) {
+	if os.Getenv(EnvName(account, CredAccess)) == "" {
+		return nil, ErrNoKey
+	}
+	return getFields(account, func(field string) (string, error) {
+		v := os.Getenv(EnvName(account, field))
+		if v == "" {
+			return "", fmt.Errorf("%s is not set", EnvName(account, field))
+		}
+		return v, nil
+	})
+}
+
+func (envStore) Set(account string, c Credentials) error {
+	return ErrReadOnly
+}
+
+func (envStore) Remove(account string) error {
+	return ErrReadOnly
+}
+
+// List finds the accounts by BCEX_<ACCOUNT>_KEY
+func (envStore) List() (names []string, err error) {
+	for _, kv := range os.Environ() {
+		name := strings.SplitN(kv, "=", 2)[0]
+		if !strings.HasPrefix(name, "BCEX_") || !strings.HasSuffix(name, "_KEY") {
+			continue
+		}
+		account := strings.ToLower(strings.TrimSuffix(strings.TrimPrefix(name, "BCEX_"), "_KEY"))
+		account = strings.Replace(account, "_", "/", 1)
+		if GetEx(ExName(account)) != nil && EnvName(account, CredAccess) == name {
+			names = append(names, account)
+		}
+	}
+	sort.Strings(names)
+	return
+}
+
+// commandStore runs the command by sh with {account} and {field} replaced,
+// like "pass show bcex/{account}/{field}", which prints the credential.
+// They are replaced by "$BCEX_ACCOUNT" and "$BCEX_FIELD", set to the
+// values in the environment of the command, so the shell never parses an
+// account name.
+type commandStore struct {
+	command string
+}
+
+func (cs commandStore) Get(account string) (Credentials, error) {
+	command := strings.NewReplacer("{account}", `"$BCEX_ACCOUNT"`,
+		"{field}", `"$BCEX_FIELD"`).Replace(cs.command)
+	return getFields(account, func(field string) (string, error) {
+		var stderr bytes.Buffer
+		c := exec.Command("sh", "-c", command)
+		c.Env = append(os.Environ(), "BCEX_ACCOUNT="+account, "BCEX_FIELD="+field)
+		c.Stderr = &stderr
+		out, err := c.Output()
+		if err != nil {
+			return "", fmt.Errorf("%s of %s: %v %s", cs.command, account, err,
+				strings.TrimSpace(stderr.String()))
+		}
+		return strings.TrimSpace(string(out)), nil
+	})
+}
+
+func (cs commandStore) Set(account string, c Credentials) error {
+	return ErrReadOnly
+}
+
+func (cs commandStore) Remove(account string) error {
+	return ErrReadOnly
+}
+
+func (cs commandStore) List() ([]string, error) {
+	return nil, ErrNoList
+}
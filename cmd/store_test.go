@@ -0,0 +1,218 @@
+package cmd
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	. "github.com/RichardWeiYang/bcex/lib"
+)
+
+// fakeVault stands in for the kv version 2 engine of Vault at secret/
+func fakeVault(t *testing.T, token string) *httptest.Server {
+	secrets := map[string]Credentials{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != token {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+
+		var reply interface{}
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/v1/secret/data/") && r.Method == "GET":
+			c, ok := secrets[strings.TrimPrefix(r.URL.Path, "/v1/secret/data/")]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			reply = map[string]interface{}{"data": map[string]interface{}{"data": c}}
+		case strings.HasPrefix(r.URL.Path, "/v1/secret/data/") && r.Method == "POST":
+			var body struct{ Data Credentials }
+			json.NewDecoder(r.Body).Decode(&body)
+			secrets[strings.TrimPrefix(r.URL.Path, "/v1/secret/data/")] = body.Data
+		case strings.HasPrefix(r.URL.Path, "/v1/secret/metadata/") && r.Method == "GET":
+			if _, ok := secrets[strings.TrimPrefix(r.URL.Path, "/v1/secret/metadata/")]; !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			reply = map[string]interface{}{"data": map[string]interface{}{"current_version": 1}}
+		case strings.HasPrefix(r.URL.Path, "/v1/secret/metadata/") && r.Method == "DELETE":
+			delete(secrets, strings.TrimPrefix(r.URL.Path, "/v1/secret/metadata/"))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		case strings.HasPrefix(r.URL.Path, "/v1/secret/metadata/") && r.Method == "LIST":
+			dir := strings.TrimPrefix(r.URL.Path, "/v1/secret/metadata/") + "/"
+			keys := map[string]bool{}
+			for p := range secrets {
+				if strings.HasPrefix(p, dir) {
+					k := strings.TrimPrefix(p, dir)
+					if i := strings.Index(k, "/"); i >= 0 {
+						k = k[:i+1]
+					}
+					keys[k] = true
+				}
+			}
+			if len(keys) == 0 {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			var list []string
+			for k := range keys {
+				list = append(list, k)
+			}
+			reply = map[string]interface{}{"data": map[string]interface{}{"keys": list}}
+		default:
+			t.Errorf("unexpected %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		json.NewEncoder(w).Encode(reply)
+	}))
+}
+
+func TestVaultStore(t *testing.T) {
+	srv := fakeVault(t, "token")
+	defer srv.Close()
+	vs := NewVaultStore(srv.URL, "token")
+
+	binance := Credentials{CredAccess: "a", CredSecret: "s"}
+	for _, account := range []string{"binance", "binance/mm", "bitstamp"} {
+		if err := vs.Set(account, binance); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if c, err := vs.Get("binance/mm"); err != nil || !reflect.DeepEqual(c, binance) {
+		t.Errorf("Get = %v, %v", c, err)
+	}
+	if _, err := vs.Get("huobi"); err != ErrNoKey {
+		t.Errorf("Get of missing account = %v", err)
+	}
+	if err := vs.Remove("bitstamp"); err != nil {
+		t.Error(err)
+	}
+	if err := vs.Remove("bitstamp"); err != ErrNoKey {
+		t.Errorf("Remove of missing account = %v", err)
+	}
+	names, err := vs.List()
+	if err != nil || !reflect.DeepEqual(names, []string{"binance", "binance/mm"}) {
+		t.Errorf("List = %v, %v", names, err)
+	}
+
+	if _, err := NewVaultStore(srv.URL, "wrong").Get("binance"); err == nil ||
+		!strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("Get with wrong token = %v", err)
+	}
+}
+
+func TestEnvStore(t *testing.T) {
+	os.Setenv("BCEX_BITSTAMP_MAIN_KEY", "a")
+	os.Setenv("BCEX_BITSTAMP_MAIN_SECRET", "s")
+	os.Setenv("BCEX_BITSTAMP_MAIN_CUSTOMER_ID", "c")
+	defer os.Unsetenv("BCEX_BITSTAMP_MAIN_KEY")
+	defer os.Unsetenv("BCEX_BITSTAMP_MAIN_SECRET")
+	defer os.Unsetenv("BCEX_BITSTAMP_MAIN_CUSTOMER_ID")
+
+	c, err := envStore{}.Get("bitstamp/main")
+	want := Credentials{CredAccess: "a", CredSecret: "s", CredCustomerId: "c"}
+	if err != nil || !reflect.DeepEqual(c, want) {
+		t.Errorf("Get = %v, %v", c, err)
+	}
+	if names, _ := (envStore{}).List(); !reflect.DeepEqual(names, []string{"bitstamp/main"}) {
+		t.Errorf("List = %v", names)
+	}
+}
+
+func TestCommandStore(t *testing.T) {
+	pwned := filepath.Join(t.TempDir(), "pwned")
+	account := "binance/x;touch " + pwned + ";$(touch " + pwned + ")"
+
+	c, err := commandStore{"echo {account}:{field}"}.Get(account)
+	want := Credentials{CredAccess: account + ":" + CredAccess, CredSecret: account + ":" + CredSecret}
+	if err != nil || !reflect.DeepEqual(c, want) {
+		t.Errorf("Get = %v, %v, want %v", c, err, want)
+	}
+	if _, err := os.Stat(pwned); err == nil {
+		t.Error("account name is run by the shell")
+	}
+
+	c, err = commandStore{`echo "$BCEX_FIELD"`}.Get("binance")
+	if err != nil || c[CredSecret] != CredSecret {
+		t.Errorf("Get = %v, %v", c, err)
+	}
+}
+
+func TestFileStoreMigrate(t *testing.T) {
+	key := "0123456789abcdef"
+	plain := `{"binance":{"AccessKeyId":"a","SecretKeyId":"s"}}`
+	gcm, err := Encrypt(plain, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := aes.NewCipher([]byte(key))
+	cfb := make([]byte, aes.BlockSize+len(plain))
+	cipher.NewCFBEncrypter(block, cfb[:aes.BlockSize]).XORKeyStream(cfb[aes.BlockSize:], []byte(plain))
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	defer func(c *string) { configPath = c }(configPath)
+	configPath = nil
+
+	cases := []struct {
+		name string
+		raw  string
+		mode os.FileMode
+	}{
+		{"gcm", gcm, 0600},
+		{"cfb", string(cfb), 0600},
+		// as older bcex wrote it
+		{"cfb readable by all", string(cfb), 0644},
+	}
+	for _, c := range cases {
+		if err := os.Chdir(t.TempDir()); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile("config.json", []byte(c.raw), c.mode); err != nil {
+			t.Fatal(err)
+		}
+
+		path := filepath.Join(t.TempDir(), "bcex", "config.json")
+		fs := &fileStore{path: path, passphrase: key}
+		if err := fs.load(); err != nil {
+			t.Fatalf("%s: load = %v", c.name, err)
+		}
+		if creds, err := fs.Get("binance"); err != nil || creds[CredAccess] != "a" {
+			t.Errorf("%s: Get = %v, %v", c.name, creds, err)
+		}
+		if _, err := os.Stat("config.json"); !os.IsNotExist(err) {
+			t.Errorf("%s: ./config.json is left: %v", c.name, err)
+		}
+		if fi, err := os.Stat(path); err != nil {
+			t.Error(err)
+		} else if fi.Mode().Perm() != 0600 {
+			t.Errorf("%s: %s is written with %v", c.name, path, fi.Mode())
+		}
+		raw, _ := ioutil.ReadFile(path)
+		if plain, err := Decrypt(string(raw), key); err != nil || !strings.Contains(plain, `"binance"`) {
+			t.Errorf("%s: %s is %q, %v", c.name, path, plain, err)
+		}
+	}
+
+	// a file in the current format is not rewritten, but refused
+	path := filepath.Join(t.TempDir(), "config.json")
+	ioutil.WriteFile(path, []byte(gcm), 0640)
+	if err := (&fileStore{path: path, passphrase: key}).load(); err == nil {
+		t.Error("config.json readable by the group is taken")
+	}
+}
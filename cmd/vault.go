@@ -0,0 +1,154 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	. "github.com/RichardWeiYang/bcex/lib"
+)
+
+/*
+ * Reference page: https://developer.hashicorp.com/vault/api-docs/secret/kv/kv-v2
+ *
+ * The credentials of an account are the fields of secret Prefix/ACCOUNT in
+ * the kv version 2 engine mounted at Mount.
+ */
+
+type VaultStore struct {
+	Addr, Token   string
+	Mount, Prefix string
+}
+
+// NewVaultStore returns the store on the Vault at addr, the local one
+// if addr is empty.
+func NewVaultStore(addr, token string) *VaultStore {
+	if addr == "" {
+		addr = "http://127.0.0.1:8200"
+	}
+	return &VaultStore{
+		Addr:   strings.TrimSuffix(addr, "/"),
+		Token:  token,
+		Mount:  "secret",
+		Prefix: "bcex",
+	}
+}
+
+func (vs *VaultStore) sendReq(method, kind, path string, body interface{}, v interface{}) (int, error) {
+	var rd io.Reader
+	if body != nil {
+		raw, _ := json.Marshal(body)
+		rd = bytes.NewReader(raw)
+	}
+
+	url := vs.Addr + "/v1/" + vs.Mount + "/" + kind + "/" + vs.Prefix
+	if path != "" {
+		url += "/" + path
+	}
+	req, err := http.NewRequest(method, url, rd)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("X-Vault-Token", vs.Token)
+
+	client := &http.Client{Transport: Transport, Timeout: 15 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return resp.StatusCode, nil
+	}
+	if resp.StatusCode >= 300 {
+		var e struct{ Errors []string }
+		json.Unmarshal(raw, &e)
+		if len(e.Errors) == 0 {
+			e.Errors = []string{resp.Status}
+		}
+		return resp.StatusCode, errors.New("vault: " + strings.Join(e.Errors, ", "))
+	}
+	if v != nil && len(raw) > 0 {
+		return resp.StatusCode, json.Unmarshal(raw, v)
+	}
+	return resp.StatusCode, nil
+}
+
+func (vs *VaultStore) Get(account string) (Credentials, error) {
+	var resp struct {
+		Data struct {
+			Data Credentials `json:"data"`
+		} `json:"data"`
+	}
+	status, err := vs.sendReq("GET", "data", account, nil, &resp)
+	if err != nil {
+		return nil, err
+	}
+	if status == http.StatusNotFound || len(resp.Data.Data) == 0 {
+		return nil, ErrNoKey
+	}
+	return resp.Data.Data, nil
+}
+
+func (vs *VaultStore) Set(account string, c Credentials) error {
+	_, err := vs.sendReq("POST", "data", account, map[string]interface{}{"data": c}, nil)
+	return err
+}
+
+// Remove deletes every version of the secret of account. Vault deletes a
+// missing path without complaint, so its metadata is looked up first.
+func (vs *VaultStore) Remove(account string) error {
+	status, err := vs.sendReq("GET", "metadata", account, nil, nil)
+	if err != nil {
+		return err
+	}
+	if status == http.StatusNotFound {
+		return ErrNoKey
+	}
+	_, err = vs.sendReq("DELETE", "metadata", account, nil, nil)
+	return err
+}
+
+// List walks the folders of EX/PROFILE under Prefix
+func (vs *VaultStore) List() (names []string, err error) {
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		var resp struct {
+			Data struct {
+				Keys []string `json:"keys"`
+			} `json:"data"`
+		}
+		if _, err := vs.sendReq("LIST", "metadata", dir, nil, &resp); err != nil {
+			return err
+		}
+		for _, k := range resp.Data.Keys {
+			name := strings.TrimPrefix(dir+"/"+k, "/")
+			if strings.HasSuffix(k, "/") {
+				if err := walk(strings.TrimSuffix(name, "/")); err != nil {
+					return err
+				}
+			} else {
+				names = append(names, name)
+			}
+		}
+		return nil
+	}
+
+	if err = walk(""); err != nil {
+		return nil, fmt.Errorf("list %s: %v", vs.Prefix, err)
+	}
+	sort.Strings(names)
+	return
+}
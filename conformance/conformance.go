@@ -0,0 +1,402 @@
+package conformance
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/RichardWeiYang/bcex/lib"
+)
+
+/*
+ * Package conformance checks an Exchange, built in or not, behaves as the
+ * rest of bcex expects:
+ *
+ *   symbols are lower case base_quote
+ *   depth has Asks ascending and Bids descending, best price first
+ *   orders are in state Alive, Cancelled or Filled
+ *   remain = amount - executed
+ *
+ * Each Case holds the recorded responses of one method, in the format of
+ * lib.Cassette. Run serves them from a local stand-in server, to which
+ * every request of the exchange is redirected, and checks the result.
+ *
+ * A third party exchange records its cases with lib.RecordCassette, then
+ *
+ *	func TestConformance(t *testing.T) {
+ *		cases, err := conformance.LoadCases("testdata/myex")
+ *		if err != nil {
+ *			t.Fatal(err)
+ *		}
+ *		conformance.Run(t, NewMyEx, lib.NewCurrencyPair2("eth_btc"), cases)
+ *	}
+ */
+
+type Case struct {
+	Name         string
+	Method       string
+	WantErr      bool
+	Interactions []lib.Interaction
+}
+
+// LoadCases loads dir/METHOD[_SUFFIX].json, a suffix "_error" means the
+// method must fail on those responses.
+func LoadCases(dir string) (cases []Case, err error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return
+	}
+	for _, f := range files {
+		c, err := lib.LoadCassette(f)
+		if err != nil {
+			return nil, err
+		}
+		name := strings.TrimSuffix(filepath.Base(f), ".json")
+		cases = append(cases, Case{
+			Name:         name,
+			Method:       strings.SplitN(name, "_", 2)[0],
+			WantErr:      strings.HasSuffix(name, "_error"),
+			Interactions: c.Interactions,
+		})
+	}
+	if len(cases) == 0 {
+		return nil, errors.New("no cases in " + dir)
+	}
+	return
+}
+
+// Server is a local stand-in for an exchange, it answers with the
+// interactions it is given, in order.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	cassette *lib.Cassette
+	errs     []error
+}
+
+func NewServer(interactions []lib.Interaction) *Server {
+	s := &Server{cassette: lib.NewCassette(interactions)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	resp, err := s.cassette.RoundTrip(r)
+	if err != nil {
+		s.mu.Lock()
+		s.errs = append(s.errs, err)
+		s.mu.Unlock()
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	for k, v := range resp.Header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(resp.StatusCode)
+	w.Write(body)
+}
+
+// Err returns the first request the server could not answer.
+func (s *Server) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.errs) > 0 {
+		return s.errs[0]
+	}
+	return nil
+}
+
+// Done reports whether all interactions were served.
+func (s *Server) Done() bool {
+	return s.cassette.Done()
+}
+
+// Transport redirects every request to the server, whatever host it is
+// for. Set it as lib.Transport.
+func (s *Server) Transport() http.RoundTripper {
+	u, _ := url.Parse(s.URL)
+	return redirect{u}
+}
+
+type redirect struct {
+	to *url.URL
+}
+
+func (r redirect) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = r.to.Scheme
+	req.URL.Host = r.to.Host
+	req.Host = r.to.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// Call calls method of ex on pair, orders are built on pair too.
+func Call(ex lib.Exchange, method string, pair lib.CurrencyPair) (interface{}, error) {
+	o := lib.Order{Id: "1", CP: pair, Side: "buy", Price: 0.05, Amount: 1}
+	switch method {
+	case "GetSymbols":
+		return ex.GetSymbols()
+	case "GetDepth":
+		return ex.GetDepth(&pair)
+	case "GetPrice":
+		return ex.GetPrice(&pair)
+	case "GetBalance":
+		return ex.GetBalance()
+	case "NewOrder":
+		return ex.NewOrder(&o)
+	case "CancelOrder":
+		return nil, ex.CancelOrder(&o)
+	case "QueryOrder":
+		return ex.QueryOrder(&o)
+	}
+	md, ok := ex.(lib.MarketData)
+	switch {
+	case !ok:
+	case method == "GetTicker":
+		return md.GetTicker(&pair)
+	case method == "GetTrades":
+		return md.GetTrades(&pair)
+	case method == "GetKlines":
+		return md.GetKlines(&pair, "1m")
+	}
+	return nil, errors.New("unknown method " + method)
+}
+
+// Check checks the result of method called on pair.
+func Check(method string, pair lib.CurrencyPair, result interface{}) error {
+	switch method {
+	case "GetSymbols":
+		return CheckSymbols(result.([]string))
+	case "GetDepth":
+		return CheckDepth(result.(lib.Depth))
+	case "GetPrice":
+		return CheckPrice(result.(lib.Price))
+	case "GetBalance":
+		return CheckBalances(result.([]lib.Balance))
+	case "NewOrder":
+		if result.(string) == "" {
+			return errors.New("no order id")
+		}
+	case "QueryOrder":
+		return CheckOrder(result.(lib.Order), pair)
+	case "GetTicker":
+		return CheckPrice(lib.Price{Price: result.(lib.Ticker).Last})
+	case "GetTrades":
+		return CheckTrades(result.([]lib.Trade))
+	case "GetKlines":
+		return CheckKlines(result.([]lib.Kline))
+	}
+	return nil
+}
+
+// operation of each method in Capabilities
+var methodOps = map[string]string{
+	"GetPrice":    lib.OpPrice,
+	"GetSymbols":  lib.OpSymbols,
+	"GetDepth":    lib.OpDepth,
+	"GetBalance":  lib.OpBalance,
+	"NewOrder":    lib.OpNewOrder,
+	"CancelOrder": lib.OpCancelOrder,
+	"QueryOrder":  lib.OpQueryOrder,
+	"GetTicker":   lib.OpTicker,
+	"GetTrades":   lib.OpTrades,
+	"GetKlines":   lib.OpKline,
+}
+
+// CheckCapabilities checks ex implements the interfaces its capabilities
+// need.
+func CheckCapabilities(ex lib.Exchange) error {
+	caps := ex.Capabilities()
+	all := lib.Capabilities{Operations: lib.AllOperations}
+	for _, op := range caps.Operations {
+		if !all.Has(op) {
+			return fmt.Errorf("unknown operation %q", op)
+		}
+	}
+	if _, ok := ex.(lib.MarketData); !ok {
+		for _, op := range lib.MarketDataOperations {
+			if caps.Has(op) {
+				return fmt.Errorf("%s without MarketData", op)
+			}
+		}
+	}
+	return nil
+}
+
+// Run runs every case against a new exchange from ne, sequentially as
+// lib.Transport is shared. The method of each case must be in the
+// capabilities of the exchange.
+func Run(t *testing.T, ne lib.NewExchange, pair lib.CurrencyPair, cases []Case) {
+	if err := CheckCapabilities(ne()); err != nil {
+		t.Error(err)
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.Name, func(t *testing.T) {
+			runCase(t, ne, pair, c)
+		})
+	}
+}
+
+func runCase(t *testing.T, ne lib.NewExchange, pair lib.CurrencyPair, c Case) {
+	s := NewServer(c.Interactions)
+	defer s.Close()
+	lib.Transport = s.Transport()
+	defer func() {
+		lib.Transport = nil
+		if r := recover(); r != nil {
+			t.Fatalf("%s panics: %v", c.Method, r)
+		}
+	}()
+
+	ex := ne()
+	if !ex.Capabilities().Has(methodOps[c.Method]) {
+		t.Errorf("%s is not in the capabilities", c.Method)
+	}
+	// "secret" in base64, some exchanges decode the secret key
+	ex.SetKey("access", "c2VjcmV0")
+	result, err := Call(ex, c.Method, pair)
+	if c.WantErr {
+		if err == nil {
+			t.Errorf("%s: no error", c.Method)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("%s: %v", c.Method, err)
+	}
+	if err = s.Err(); err != nil {
+		t.Fatalf("%s: %v", c.Method, err)
+	}
+	if !s.Done() {
+		t.Errorf("%s: not all responses are used", c.Method)
+	}
+	if err = Check(c.Method, pair, result); err != nil {
+		t.Errorf("%s: %v", c.Method, err)
+	}
+}
+
+func CheckSymbols(symbols []string) error {
+	if len(symbols) == 0 {
+		return errors.New("no symbols")
+	}
+	for _, s := range symbols {
+		cs := strings.Split(s, "_")
+		if s != strings.ToLower(s) || len(cs) != 2 || cs[0] == "" || cs[1] == "" {
+			return fmt.Errorf("symbol %q is not lower case base_quote", s)
+		}
+	}
+	return nil
+}
+
+func CheckDepth(depth lib.Depth) error {
+	if len(depth.Asks) == 0 || len(depth.Bids) == 0 {
+		return fmt.Errorf("empty depth %v", depth)
+	}
+	for i, u := range depth.Asks {
+		if u.Price <= 0 || u.Amount <= 0 {
+			return fmt.Errorf("bad ask %v", u)
+		}
+		if i > 0 && u.Price <= depth.Asks[i-1].Price {
+			return fmt.Errorf("asks are not ascending: %v", depth.Asks)
+		}
+	}
+	for i, u := range depth.Bids {
+		if u.Price <= 0 || u.Amount <= 0 {
+			return fmt.Errorf("bad bid %v", u)
+		}
+		if i > 0 && u.Price >= depth.Bids[i-1].Price {
+			return fmt.Errorf("bids are not descending: %v", depth.Bids)
+		}
+	}
+	if depth.Bids[0].Price >= depth.Asks[0].Price {
+		return fmt.Errorf("crossed depth, bid %v ask %v",
+			depth.Bids[0].Price, depth.Asks[0].Price)
+	}
+	return nil
+}
+
+func CheckPrice(price lib.Price) error {
+	if price.Price <= 0 {
+		return fmt.Errorf("bad price %v", price.Price)
+	}
+	return nil
+}
+
+func CheckBalances(balances []lib.Balance) error {
+	if len(balances) == 0 {
+		return errors.New("no balances")
+	}
+	for _, b := range balances {
+		if b.Currency == "" {
+			return fmt.Errorf("no currency in %v", b)
+		}
+		var f float64
+		if _, err := fmt.Sscan(b.Balance, &f); err != nil {
+			return fmt.Errorf("balance of %s %q is not a number", b.Currency, b.Balance)
+		}
+	}
+	return nil
+}
+
+// CheckOrder checks o is a normalized order on pair.
+func CheckOrder(o lib.Order, pair lib.CurrencyPair) error {
+	if o.Id == "" || o.Amount <= 0 {
+		return fmt.Errorf("incomplete order %v", o)
+	}
+	if !strings.EqualFold(o.CP.String(), pair.String()) {
+		return fmt.Errorf("order symbol %s, want %s", o.CP, pair)
+	}
+	if o.Side != "buy" && o.Side != "sell" {
+		return fmt.Errorf("order side %q", o.Side)
+	}
+	switch o.State {
+	case lib.Alive, lib.Cancelled, lib.Filled:
+	default:
+		return fmt.Errorf("order state %q is not normalized", o.State)
+	}
+	if math.Abs(o.Remain-(o.Amount-o.Executed)) > 1e-9 {
+		return fmt.Errorf("remain %v, amount %v, executed %v",
+			o.Remain, o.Amount, o.Executed)
+	}
+	return nil
+}
+
+func CheckTrades(trades []lib.Trade) error {
+	if len(trades) == 0 {
+		return errors.New("no trades")
+	}
+	for _, t := range trades {
+		if t.Price <= 0 || t.Amount <= 0 || t.Time.IsZero() {
+			return fmt.Errorf("bad trade %v", t)
+		}
+		if t.Side != "" && t.Side != "buy" && t.Side != "sell" {
+			return fmt.Errorf("trade side %q", t.Side)
+		}
+	}
+	return nil
+}
+
+func CheckKlines(klines []lib.Kline) error {
+	if len(klines) == 0 {
+		return errors.New("no klines")
+	}
+	for _, k := range klines {
+		if k.Time.IsZero() || k.Low <= 0 || k.High < k.Low ||
+			k.Open < k.Low || k.Open > k.High || k.Close < k.Low || k.Close > k.High {
+			return fmt.Errorf("bad kline %v", k)
+		}
+	}
+	return nil
+}
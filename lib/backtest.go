@@ -0,0 +1,495 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+/*
+ * Backtest replays historical events through a simulated Exchange, so a
+ * strategy is tested offline against the same interface it uses live.
+ *
+ * Resting orders are filled by the configured model:
+ *   touch: filled once the market trades at the order price
+ *   queue: at the order price only the volume behind the amount queued
+ *          ahead of the order (taken from the last depth) is filled
+ * Either way an order is filled when the market trades through it. A
+ * trade only fills orders on the other side of its taker, or either side
+ * when it has none.
+ * Orders crossing the book when they arrive take liquidity, paying
+ * Slippage on top of the book price. What orders take of a depth snapshot
+ * is not there for the next order, nor in the following snapshots as long
+ * as they show the level, as with the taken liquidity of SimAccount.
+ */
+
+const (
+	TouchFill = "touch"
+	QueueFill = "queue"
+)
+
+type BacktestConfig struct {
+	CP       CurrencyPair
+	Fill     string
+	Slippage float64       // fraction of price, 0.001 is 10 bps
+	Fee      float64       // fraction of each fill
+	Latency  time.Duration // delay before orders and cancels take effect
+	Balances map[string]float64
+}
+
+// Strategy is called with the simulated exchange after every event.
+type Strategy func(ex Exchange) error
+
+type Sample struct {
+	Time     time.Time
+	Price    float64
+	Equity   float64
+	PnL      float64
+	Drawdown float64
+	Exposure float64
+}
+
+type Report struct {
+	Fills       []Fill
+	Samples     []Sample
+	StartEquity float64
+	EndEquity   float64
+	PnL         float64
+	Fees        float64
+	MaxDrawdown float64
+	MaxExposure float64
+}
+
+type pendingOp struct {
+	at     time.Time
+	id     string
+	cancel bool
+}
+
+type Backtest struct {
+	cfg     BacktestConfig
+	account *SimAccount
+	now     time.Time
+	price   float64
+	depth   *Depth // the last snapshot less taken, best price first
+	taken   Depth
+	pending []pendingOp
+	active  map[string]bool
+	queue   map[string]float64
+}
+
+func NewBacktest(cfg BacktestConfig) *Backtest {
+	if cfg.Fill == "" {
+		cfg.Fill = TouchFill
+	}
+	bt := &Backtest{
+		cfg:     cfg,
+		account: NewSimAccount(cfg.Fee),
+		active:  map[string]bool{},
+		queue:   map[string]float64{},
+	}
+	for c, amount := range cfg.Balances {
+		bt.account.Deposit(c, amount)
+	}
+	return bt
+}
+
+// Now is the time of the event being replayed.
+func (bt *Backtest) Now() time.Time {
+	return bt.now
+}
+
+func (bt *Backtest) Run(events []Event, strategy Strategy) (report Report, err error) {
+	if bt.cfg.Fill != TouchFill && bt.cfg.Fill != QueueFill {
+		return report, errors.New("Unknown fill model: " + bt.cfg.Fill)
+	}
+
+	var peak float64
+	for _, ev := range events {
+		bt.now = ev.Time
+		if err = bt.process(); err != nil {
+			return
+		}
+		if err = bt.match(&ev); err != nil {
+			return
+		}
+		bt.update(&ev)
+		if bt.price == 0 {
+			continue
+		}
+		if len(report.Samples) == 0 {
+			report.StartEquity = bt.equity()
+			peak = report.StartEquity
+		}
+
+		if err = strategy(bt); err != nil {
+			return
+		}
+
+		s := Sample{Time: bt.now, Price: bt.price, Equity: bt.equity()}
+		s.PnL = s.Equity - report.StartEquity
+		s.Exposure = bt.account.Total(bt.cfg.CP.CurrencyA.Symbol) * bt.price
+		peak = math.Max(peak, s.Equity)
+		if peak > 0 {
+			s.Drawdown = (peak - s.Equity) / peak
+		}
+		report.MaxDrawdown = math.Max(report.MaxDrawdown, s.Drawdown)
+		report.MaxExposure = math.Max(report.MaxExposure, math.Abs(s.Exposure))
+		report.Samples = append(report.Samples, s)
+	}
+
+	report.Fills = bt.account.Fills
+	for _, f := range report.Fills {
+		if f.Side == "buy" {
+			report.Fees += f.Fee * f.Price
+		} else {
+			report.Fees += f.Fee
+		}
+	}
+	if n := len(report.Samples); n > 0 {
+		report.EndEquity = report.Samples[n-1].Equity
+		report.PnL = report.EndEquity - report.StartEquity
+	}
+	return
+}
+
+// equity of the account valued in the quote currency
+func (bt *Backtest) equity() float64 {
+	return bt.account.Total(bt.cfg.CP.CurrencyB.Symbol) +
+		bt.account.Total(bt.cfg.CP.CurrencyA.Symbol)*bt.price
+}
+
+func (bt *Backtest) process() (err error) {
+	var later []pendingOp
+	for _, op := range bt.pending {
+		if op.at.After(bt.now) {
+			later = append(later, op)
+		} else if op.cancel {
+			bt.account.Cancel(op.id)
+		} else if err = bt.activate(op.id); err != nil {
+			return
+		}
+	}
+	bt.pending = later
+	return
+}
+
+// activate lets an order reach the market: it takes what it crosses and
+// rests with the remain.
+func (bt *Backtest) activate(id string) error {
+	o, ok := bt.account.Orders[id]
+	if !ok || o.State != Alive {
+		return nil
+	}
+
+	slip := 1 + bt.cfg.Slippage
+	if o.Side == "sell" {
+		slip = 1 - bt.cfg.Slippage
+	}
+	taker := func(price, amount float64) error {
+		price *= slip
+		if o.Side == "buy" {
+			price = math.Min(price, o.Price)
+		} else {
+			price = math.Max(price, o.Price)
+		}
+		return bt.account.Fill(id, price, amount, bt.now)
+	}
+
+	if bt.depth != nil {
+		book, taken := &bt.depth.Asks, &bt.taken.Asks
+		if o.Side == "sell" {
+			book, taken = &bt.depth.Bids, &bt.taken.Bids
+		}
+		for i, u := range *book {
+			if o.State != Alive || !crosses(o, u.Price) {
+				break
+			}
+			amount := math.Min(u.Amount, o.Remain)
+			if err := taker(u.Price, amount); err != nil {
+				return err
+			}
+			(*book)[i].Amount -= amount
+			*taken = addUnit(*taken, u.Price, amount)
+		}
+		*book = nonEmptyUnits(*book)
+	} else if bt.price > 0 && crosses(o, bt.price) {
+		if err := taker(bt.price, o.Remain); err != nil {
+			return err
+		}
+	}
+
+	if o.State == Alive {
+		bt.active[id] = true
+		bt.queue[id] = queued(o, bt.depth)
+	}
+	return nil
+}
+
+// addUnit adds amount to the level at price of units
+func addUnit(units []Unit, price, amount float64) []Unit {
+	for i := range units {
+		if units[i].Price == price {
+			units[i].Amount += amount
+			return units
+		}
+	}
+	return append(units, Unit{price, amount})
+}
+
+// queued is the amount ahead of o at its price level in depth
+func queued(o *Order, depth *Depth) float64 {
+	if depth == nil {
+		return 0
+	}
+	units := depth.Bids
+	if o.Side == "sell" {
+		units = depth.Asks
+	}
+	for _, u := range units {
+		if u.Price == o.Price {
+			return u.Amount
+		}
+	}
+	return 0
+}
+
+// crosses reports whether the market at price reaches order o
+func crosses(o *Order, price float64) bool {
+	if price == o.Price {
+		return true
+	}
+	if o.Side == "buy" {
+		return price < o.Price
+	}
+	return price > o.Price
+}
+
+// match fills the active orders the event reaches. A depth snapshot
+// becomes the book, less what was taken of the levels it still shows and
+// what the orders take of it now.
+func (bt *Backtest) match(ev *Event) error {
+	var asks, bids, takenAsks, takenBids []Unit
+	if ev.Depth != nil {
+		asks = sortUnits(ev.Depth.Asks, true)
+		bids = sortUnits(ev.Depth.Bids, false)
+		takenAsks = takeUnits(asks, bt.taken.Asks)
+		takenBids = takeUnits(bids, bt.taken.Bids)
+	}
+
+	for _, id := range bt.account.OpenOrders(&bt.cfg.CP) {
+		if !bt.active[id] {
+			continue
+		}
+		o := bt.account.Orders[id]
+
+		if ev.Trade != nil && ev.Trade.Side != o.Side {
+			if err := bt.trade(o, ev.Trade.Price, ev.Trade.Amount); err != nil {
+				return err
+			}
+		}
+		if ev.Kline != nil {
+			extreme := ev.Kline.Low
+			if o.Side == "sell" {
+				extreme = ev.Kline.High
+			}
+			if err := bt.trade(o, extreme, ev.Kline.Volume); err != nil {
+				return err
+			}
+		}
+		if ev.Depth != nil && o.State == Alive {
+			book, took := asks, takenAsks
+			if o.Side == "sell" {
+				book, took = bids, takenBids
+			}
+			for i := range book {
+				if o.State != Alive || !crosses(o, book[i].Price) {
+					break
+				}
+				amount := book[i].Amount
+				if bt.cfg.Fill == QueueFill && book[i].Price == o.Price {
+					amount = bt.behind(o, amount)
+				}
+				amount = math.Min(amount, o.Remain)
+				if amount <= 0 {
+					continue
+				}
+				if err := bt.account.Fill(id, o.Price, amount, bt.now); err != nil {
+					return err
+				}
+				book[i].Amount -= amount
+				took[i].Amount += amount
+			}
+			if bt.cfg.Fill == QueueFill && o.State == Alive {
+				bt.queue[id] = math.Min(bt.queue[id], queued(o, ev.Depth))
+			}
+		}
+
+		if o.State != Alive {
+			delete(bt.active, id)
+			delete(bt.queue, id)
+		}
+	}
+
+	if ev.Depth != nil {
+		bt.depth = &Depth{Asks: nonEmptyUnits(asks), Bids: nonEmptyUnits(bids)}
+		bt.taken = Depth{Asks: nonEmptyUnits(takenAsks), Bids: nonEmptyUnits(takenBids)}
+	}
+	return nil
+}
+
+// trade fills o against volume traded at price
+func (bt *Backtest) trade(o *Order, price, volume float64) error {
+	if o.State != Alive || !crosses(o, price) {
+		return nil
+	}
+	if bt.cfg.Fill == TouchFill || price != o.Price {
+		return bt.account.Fill(o.Id, o.Price, o.Remain, bt.now)
+	}
+
+	if amount := bt.behind(o, volume); amount > 0 {
+		return bt.account.Fill(o.Id, o.Price, amount, bt.now)
+	}
+	return nil
+}
+
+// behind takes volume at the price of o off the queue ahead of it and
+// returns what is left over for o
+func (bt *Backtest) behind(o *Order, volume float64) float64 {
+	ahead := bt.queue[o.Id]
+	bt.queue[o.Id] = math.Max(ahead-volume, 0)
+	return volume - ahead
+}
+
+func (bt *Backtest) update(ev *Event) {
+	if ev.Depth != nil {
+		asks := sortUnits(ev.Depth.Asks, true)
+		bids := sortUnits(ev.Depth.Bids, false)
+		if len(asks) > 0 && len(bids) > 0 {
+			bt.price = (asks[0].Price + bids[0].Price) / 2
+		}
+	}
+	if ev.Kline != nil {
+		bt.price = ev.Kline.Close
+	}
+	if ev.Trade != nil {
+		bt.price = ev.Trade.Price
+	}
+}
+
+func (bt *Backtest) checkPair(cp *CurrencyPair) error {
+	if !strings.EqualFold(cp.String(), bt.cfg.CP.String()) {
+		return errors.New("Unsupported symbol: " + cp.String())
+	}
+	return nil
+}
+
+func (bt *Backtest) Capabilities() Capabilities {
+	return Capabilities{
+		Operations: BasicOperations,
+		OrderTypes: []string{LimitOrder},
+		Features:   []string{Simulated},
+	}
+}
+
+func (bt *Backtest) ToSymbol(cp *CurrencyPair) string {
+	return cp.ToSymbol("_")
+}
+
+func (bt *Backtest) NormSymbol(cp *string) string {
+	return *cp
+}
+
+func (bt *Backtest) OrderState(s interface{}) string {
+	if state, ok := s.(string); ok {
+		return state
+	}
+	return Unknown
+}
+
+func (bt *Backtest) OrderSide(s string) string {
+	return s
+}
+
+func (bt *Backtest) SetKey(access, secret string) {
+}
+
+func (bt *Backtest) GetPrice(cp *CurrencyPair) (price Price, err error) {
+	if err = bt.checkPair(cp); err != nil {
+		return
+	}
+	if bt.price == 0 {
+		return price, errors.New("No market data yet")
+	}
+	return Price{bt.price}, nil
+}
+
+func (bt *Backtest) GetSymbols() (symbols []string, err error) {
+	return []string{strings.ToLower(bt.cfg.CP.String())}, nil
+}
+
+func (bt *Backtest) GetDepth(cp *CurrencyPair) (depth Depth, err error) {
+	if err = bt.checkPair(cp); err != nil {
+		return
+	}
+	if bt.depth == nil {
+		return depth, errors.New("No depth data")
+	}
+	depth.Asks = append(depth.Asks, bt.depth.Asks...)
+	depth.Bids = append(depth.Bids, bt.depth.Bids...)
+	return
+}
+
+func (bt *Backtest) GetBalance() (balances []Balance, err error) {
+	return bt.account.GetBalance(), nil
+}
+
+func (bt *Backtest) NewOrder(o *Order) (id string, err error) {
+	if err = bt.checkPair(&o.CP); err != nil {
+		return
+	}
+	id, err = bt.account.Place(o)
+	if err != nil {
+		return
+	}
+	if bt.cfg.Latency == 0 {
+		err = bt.activate(id)
+	} else {
+		bt.pending = append(bt.pending,
+			pendingOp{at: bt.now.Add(bt.cfg.Latency), id: id})
+	}
+	return
+}
+
+func (bt *Backtest) CancelOrder(o *Order) (err error) {
+	if _, err = bt.account.aliveOrder(o.Id); err != nil {
+		return
+	}
+	if bt.cfg.Latency == 0 {
+		return bt.account.Cancel(o.Id)
+	}
+	bt.pending = append(bt.pending,
+		pendingOp{at: bt.now.Add(bt.cfg.Latency), id: o.Id, cancel: true})
+	return
+}
+
+func (bt *Backtest) QueryOrder(o *Order) (order Order, err error) {
+	return bt.account.Query(o.Id)
+}
+
+func (r *Report) Print(w io.Writer) {
+	fmt.Fprintln(w, "Trades:")
+	for _, f := range r.Fills {
+		fmt.Fprintf(w, "\t%s\t%s\t%s\t%0.8f\t%0.8f\n",
+			f.Time.Format(time.RFC3339), f.OrderId, f.Side, f.Price, f.Amount)
+	}
+	fmt.Fprintf(w, "Start Equity: %0.8f\n", r.StartEquity)
+	fmt.Fprintf(w, "End Equity:   %0.8f\n", r.EndEquity)
+	fmt.Fprintf(w, "PnL:          %0.8f\n", r.PnL)
+	fmt.Fprintf(w, "Fees:         %0.8f\n", r.Fees)
+	fmt.Fprintf(w, "Max Drawdown: %0.2f%%\n", r.MaxDrawdown*100)
+	fmt.Fprintf(w, "Max Exposure: %0.8f\n", r.MaxExposure)
+}
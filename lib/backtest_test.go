@@ -0,0 +1,171 @@
+package lib
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// queueEvents has 3 bid at 0.05 ahead of a buy order placed there, then
+// trades 2 and an ask of 1.5 at 0.05 and trades 1 more.
+func queueEvents() []Event {
+	start := time.Unix(1500000000, 0)
+	return []Event{
+		{Time: start, Depth: &Depth{Asks: []Unit{{0.06, 1}}, Bids: []Unit{{0.05, 3}}}},
+		{Time: start.Add(time.Second), Trade: &Trade{Price: 0.05, Amount: 2, Side: "sell"}},
+		{Time: start.Add(2 * time.Second), Depth: &Depth{Asks: []Unit{{0.05, 1.5}}, Bids: []Unit{{0.05, 4}}}},
+		{Time: start.Add(3 * time.Second), Trade: &Trade{Price: 0.05, Amount: 1, Side: "sell"}},
+	}
+}
+
+func TestBacktestFillModels(t *testing.T) {
+	cases := []struct {
+		fill     string
+		executed []float64 // after each event
+	}{
+		{TouchFill, []float64{0, 1, 1, 1}},
+		{QueueFill, []float64{0, 0, 0.5, 1}},
+	}
+	for _, c := range cases {
+		bt := NewBacktest(BacktestConfig{CP: simPair, Fill: c.fill,
+			Balances: map[string]float64{"btc": 1}})
+		var id string
+		var executed []float64
+		_, err := bt.Run(queueEvents(), func(ex Exchange) (err error) {
+			if id == "" {
+				id, err = ex.NewOrder(&Order{CP: simPair, Side: "buy", Price: 0.05, Amount: 1})
+			}
+			o, _ := ex.QueryOrder(&Order{Id: id})
+			executed = append(executed, o.Executed)
+			return
+		})
+		if err != nil || !reflect.DeepEqual(executed, c.executed) {
+			t.Errorf("%s: executed %v, %v, want %v", c.fill, executed, err, c.executed)
+		}
+	}
+}
+
+func TestBacktestFees(t *testing.T) {
+	bt := NewBacktest(BacktestConfig{CP: simPair, Fee: 0.01, Slippage: 0.1,
+		Balances: map[string]float64{"btc": 1}})
+	events := queueEvents()[:1]
+	report, err := bt.Run(events, func(ex Exchange) error {
+		if len(bt.account.Orders) > 0 {
+			return nil
+		}
+		// crosses the ask at 0.06, slippage is capped by the order price
+		_, err := ex.NewOrder(&Order{CP: simPair, Side: "buy", Price: 0.065, Amount: 1})
+		return err
+	})
+	if err != nil || len(report.Fills) != 1 {
+		t.Fatalf("fills %v, %v", report.Fills, err)
+	}
+	f := report.Fills[0]
+	if f.Price != 0.065 || f.Amount != 1 || !near(f.Fee, 0.01) || !near(report.Fees, 0.01*0.065) {
+		t.Errorf("fill %+v, fees %v", f, report.Fees)
+	}
+	if eth := bt.account.Total("eth"); !near(eth, 0.99) {
+		t.Errorf("eth = %v", eth)
+	}
+}
+
+func TestBacktestCancel(t *testing.T) {
+	bt := NewBacktest(BacktestConfig{CP: simPair, Latency: time.Second,
+		Balances: map[string]float64{"btc": 1}})
+	var id string
+	var cancelErr error
+	bt.Run(queueEvents(), func(ex Exchange) (err error) {
+		switch {
+		case id == "":
+			id, err = ex.NewOrder(&Order{CP: simPair, Side: "buy", Price: 0.05, Amount: 1})
+		case bt.Now().Equal(queueEvents()[1].Time):
+			// the trade at this event reached the order before the cancel
+			cancelErr = ex.CancelOrder(&Order{Id: id})
+		}
+		return
+	})
+	o, _ := bt.QueryOrder(&Order{Id: id})
+	if cancelErr == nil || o.State != Filled {
+		t.Errorf("order %+v, cancel %v", o, cancelErr)
+	}
+
+	bt = NewBacktest(BacktestConfig{CP: simPair, Fill: QueueFill,
+		Balances: map[string]float64{"btc": 1}})
+	id = ""
+	bt.Run(queueEvents()[:2], func(ex Exchange) (err error) {
+		if id == "" {
+			id, err = ex.NewOrder(&Order{CP: simPair, Side: "buy", Price: 0.05, Amount: 1})
+		} else {
+			err = ex.CancelOrder(&Order{Id: id})
+		}
+		return
+	})
+	if o, _ = bt.QueryOrder(&Order{Id: id}); o.State != Cancelled || bt.account.Total("btc") != 1 {
+		t.Errorf("order %+v, btc %v", o, bt.account.Total("btc"))
+	}
+}
+
+func TestBacktestTakesDepth(t *testing.T) {
+	start := time.Unix(1500000000, 0)
+	events := []Event{
+		{Time: start, Depth: &Depth{Asks: []Unit{{0.06, 2}}, Bids: []Unit{{0.04, 1}}}},
+		// the level at 0.05 is shared by both orders
+		{Time: start.Add(time.Second), Depth: &Depth{Asks: []Unit{{0.05, 1.5}, {0.06, 2}}, Bids: []Unit{{0.04, 1}}}},
+		// and is not there again while the book still shows it
+		{Time: start.Add(2 * time.Second), Depth: &Depth{Asks: []Unit{{0.05, 1.5}, {0.06, 2}}, Bids: []Unit{{0.04, 1}}}},
+	}
+	bt := NewBacktest(BacktestConfig{CP: simPair, Balances: map[string]float64{"btc": 1}})
+	var ids []string
+	_, err := bt.Run(events, func(ex Exchange) error {
+		if len(ids) > 0 {
+			return nil
+		}
+		// takes 1 at 0.06, which the book has no more
+		if _, err := ex.NewOrder(&Order{CP: simPair, Side: "buy", Price: 0.06, Amount: 1}); err != nil {
+			return err
+		}
+		if depth, _ := ex.GetDepth(&simPair); !reflect.DeepEqual(depth.Asks, []Unit{{0.06, 1}}) {
+			t.Errorf("asks after the taker order = %v", depth.Asks)
+		}
+		for i := 0; i < 2; i++ {
+			id, err := ex.NewOrder(&Order{CP: simPair, Side: "buy", Price: 0.05, Amount: 1})
+			if err != nil {
+				return err
+			}
+			ids = append(ids, id)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, _ := bt.QueryOrder(&Order{Id: ids[0]})
+	b, _ := bt.QueryOrder(&Order{Id: ids[1]})
+	if a.Executed != 1 || b.Executed != 0.5 {
+		t.Errorf("orders executed %v and %v, want 1 and 0.5", a.Executed, b.Executed)
+	}
+}
+
+func TestBacktestTradeSide(t *testing.T) {
+	start := time.Unix(1500000000, 0)
+	events := []Event{
+		{Time: start, Depth: &Depth{Asks: []Unit{{0.06, 1}}, Bids: []Unit{{0.04, 1}}}},
+		// a buyer taking 0.05 doesn't reach a bid there
+		{Time: start.Add(time.Second), Trade: &Trade{Price: 0.05, Amount: 1, Side: "buy"}},
+		{Time: start.Add(2 * time.Second), Trade: &Trade{Price: 0.05, Amount: 1}},
+	}
+	bt := NewBacktest(BacktestConfig{CP: simPair, Balances: map[string]float64{"btc": 1}})
+	var id string
+	var executed []float64
+	bt.Run(events, func(ex Exchange) (err error) {
+		if id == "" {
+			id, err = ex.NewOrder(&Order{CP: simPair, Side: "buy", Price: 0.05, Amount: 1})
+		}
+		o, _ := ex.QueryOrder(&Order{Id: id})
+		executed = append(executed, o.Executed)
+		return
+	})
+	if want := []float64{0, 0, 1}; !reflect.DeepEqual(executed, want) {
+		t.Errorf("executed %v, want %v", executed, want)
+	}
+}
@@ -18,7 +18,7 @@ import (
  */
 
 type BigOne struct {
-	accesskeyid, secretkeyid string
+	accesskeyid, deviceid string
 }
 
 func (bo *BigOne) respErr(js *Json) (interface{}, error) {
@@ -50,7 +50,7 @@ func (bo *BigOne) sendReq(method, path string,
 		header = map[string][]string{
 			"Authorization": {"Bearer " + bo.accesskeyid},
 			"User-Agent":    {`standard browser user agent format`},
-			"Big-Device-Id": {bo.secretkeyid},
+			"Big-Device-Id": {bo.deviceid},
 			"Content-Type":  {`application/json`},
 		}
 		req.Header = header
@@ -71,13 +71,28 @@ func (bo *BigOne) GetBalance() (balances []Balance, err error) {
 	}
 
 	respOk := func(js *Json) (interface{}, error) {
-		bs, _ := js.Get("data").Array()
+		bs, err := toArray(js.Get("data").Interface())
+		if err != nil {
+			return nil, err
+		}
 		for _, b := range bs {
-			bt := b.(map[string]interface{})
-			if bt["active_balance"].(string) != "0.00000000" {
+			bt, err := toMap(b)
+			if err != nil {
+				return nil, err
+			}
+			currency, err := toString(bt["account_type"])
+			if err != nil {
+				return nil, err
+			}
+			active, err := toString(bt["active_balance"])
+			if err != nil {
+				return nil, err
+			}
+			if f, err := toFloat(active); err != nil {
+				return nil, err
+			} else if f != 0 {
 				balances = append(balances,
-					Balance{Currency: bt["account_type"].(string),
-						Balance: bt["active_balance"].(string)})
+					Balance{Currency: currency, Balance: active})
 			}
 		}
 		return balances, nil
@@ -91,9 +106,18 @@ func (bo *BigOne) GetBalance() (balances []Balance, err error) {
 	return
 }
 
+// SetKey takes the access key only, a random device id is used
 func (bo *BigOne) SetKey(access, secret string) {
-	bo.accesskeyid = access
-	bo.secretkeyid = GetUUID()
+	bo.SetCredentials(Credentials{CredAccess: access})
+}
+
+func (bo *BigOne) SetCredentials(c Credentials) error {
+	bo.accesskeyid = c[CredAccess]
+	bo.deviceid = c[CredDeviceId]
+	if bo.deviceid == "" {
+		bo.deviceid = GetUUID()
+	}
+	return nil
 }
 
 func (bo *BigOne) GetPrice(cp *CurrencyPair) (price Price, err error) {
@@ -103,8 +127,10 @@ func (bo *BigOne) GetPrice(cp *CurrencyPair) (price Price, err error) {
 	}
 
 	respOk := func(js *Json) (interface{}, error) {
-		price_s, _ := js.Get("data").Get("ticker").Get("price").String()
-		price, _ := strconv.ParseFloat(price_s, 64)
+		price, err := getFloat(js.Get("data").Get("ticker"), "price")
+		if err != nil {
+			return nil, err
+		}
 		return Price{price}, nil
 	}
 
@@ -124,12 +150,24 @@ func (bo *BigOne) GetSymbols() (symbols []string, err error) {
 
 	respOk := func(js *Json) (interface{}, error) {
 		var s []string
-		data, _ := js.Get("data").Array()
+		data, err := toArray(js.Get("data").Interface())
+		if err != nil {
+			return nil, err
+		}
 		for _, d := range data {
-			dd := d.(map[string]interface{})
-			base := strings.ToLower(dd["base"].(string))
-			quote := strings.ToLower(dd["quote"].(string))
-			s = append(s, quote+"_"+base)
+			dd, err := toMap(d)
+			if err != nil {
+				return nil, err
+			}
+			base, err := toString(dd["base"])
+			if err != nil {
+				return nil, err
+			}
+			quote, err := toString(dd["quote"])
+			if err != nil {
+				return nil, err
+			}
+			s = append(s, strings.ToLower(quote+"_"+base))
 		}
 		return s, nil
 	}
@@ -149,19 +187,13 @@ func (bo *BigOne) GetDepth(cp *CurrencyPair) (depth Depth, err error) {
 
 	respOk := func(js *Json) (interface{}, error) {
 		var depth Depth
-		asks, _ := js.Get("data").Get("depth").Get("asks").Array()
-		for _, a := range asks {
-			uu := a.(map[string]interface{})
-			price, _ := strconv.ParseFloat(uu["price"].(string), 64)
-			amount, _ := strconv.ParseFloat(uu["amount"].(string), 64)
-			depth.Asks = append([]Unit{Unit{price, amount}}, depth.Asks...)
+		var err error
+		d := js.Get("data").Get("depth")
+		if depth.Asks, err = toUnitsOf(d.Get("asks").Interface(), "price", "amount"); err != nil {
+			return nil, err
 		}
-		bids, _ := js.Get("data").Get("depth").Get("bids").Array()
-		for _, b := range bids {
-			uu := b.(map[string]interface{})
-			price, _ := strconv.ParseFloat(uu["price"].(string), 64)
-			amount, _ := strconv.ParseFloat(uu["amount"].(string), 64)
-			depth.Bids = append(depth.Bids, Unit{price, amount})
+		if depth.Bids, err = toUnitsOf(d.Get("bids").Interface(), "price", "amount"); err != nil {
+			return nil, err
 		}
 		return depth, nil
 	}
@@ -182,12 +214,15 @@ func getSide(side string) string {
 }
 
 func (bo *BigOne) OrderState(s interface{}) string {
-	if s.(string) == "open" {
+	switch s {
+	case "open":
 		return Alive
-	} else if s.(string) == "canceled" {
+	case "canceled":
 		return Cancelled
+	case "filled":
+		return Filled
 	}
-	return s.(string)
+	return Unknown
 }
 
 func (bo *BigOne) OrderSide(s string) string {
@@ -212,8 +247,7 @@ func (bo *BigOne) NewOrder(o *Order) (id string, err error) {
 	}
 
 	respOk := func(js *Json) (interface{}, error) {
-		id, _ = js.Get("data").Get("order_id").String()
-		return id, nil
+		return getString(js.Get("data"), "order_id")
 	}
 
 	oid, err := ProcessResp(status, js, respOk, bo.respErr)
@@ -245,19 +279,35 @@ func (bo *BigOne) QueryOrder(o *Order) (order Order, err error) {
 
 	respOk := func(js *Json) (interface{}, error) {
 		var order Order
-		order.Id, _ = js.Get("data").Get("order_id").String()
-		market, _ := js.Get("data").Get("order_market").String()
+		var err error
+		data := js.Get("data")
+		if order.Id, err = getString(data, "order_id"); err != nil {
+			return nil, err
+		}
+		market, err := getString(data, "order_market")
+		if err != nil {
+			return nil, err
+		}
 		order.CP = NewCurrencyPair2(bo.NormSymbol(&market))
-		side, _ := js.Get("data").Get("order_side").String()
+		side, err := getString(data, "order_side")
+		if err != nil {
+			return nil, err
+		}
 		order.Side = bo.OrderSide(side)
-		price, _ := js.Get("data").Get("price").String()
-		order.Price, _ = strconv.ParseFloat(price, 64)
-		amount, _ := js.Get("data").Get("amount").String()
-		order.Amount, _ = strconv.ParseFloat(amount, 64)
-		status, _ := js.Get("data").Get("order_state").String()
-		order.State = bo.OrderState(status)
-		executed, _ := js.Get("data").Get("filled_amount").String()
-		order.Executed, _ = strconv.ParseFloat(executed, 64)
+		if order.Price, err = getFloat(data, "price"); err != nil {
+			return nil, err
+		}
+		if order.Amount, err = getFloat(data, "amount"); err != nil {
+			return nil, err
+		}
+		state, err := getString(data, "order_state")
+		if err != nil {
+			return nil, err
+		}
+		order.State = bo.OrderState(state)
+		if order.Executed, err = getFloat(data, "filled_amount"); err != nil {
+			return nil, err
+		}
 		order.Remain = order.Amount - order.Executed
 		return order, nil
 	}
@@ -269,10 +319,20 @@ func (bo *BigOne) QueryOrder(o *Order) (order Order, err error) {
 	return
 }
 
+func (bo *BigOne) Capabilities() Capabilities {
+	return Capabilities{
+		Operations: BasicOperations,
+		OrderTypes: []string{LimitOrder},
+	}
+}
+
 func NewBigOne() Exchange {
 	return new(BigOne)
 }
 
 func init() {
-	RegisterEx("bigone", NewBigOne)
+	RegisterEx("bigone", NewBigOne,
+		CredentialField{Name: CredAccess, Desc: "API key"},
+		CredentialField{Name: CredDeviceId, Desc: "Big-Device-Id, random if not set",
+			Optional: true, Named: true})
 }
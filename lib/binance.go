@@ -31,6 +31,9 @@ func (bn *Binance) ToSymbol(cp *CurrencyPair) string {
 
 func (bn *Binance) NormSymbol(cp *string) string {
 	tmp := *cp
+	if len(tmp) < 6 {
+		return strings.ToLower(tmp)
+	}
 	return strings.ToLower(tmp[:3] + "_" + tmp[3:])
 }
 
@@ -68,13 +71,28 @@ func (bn *Binance) GetBalance() (balances []Balance, err error) {
 	}
 
 	respOk := func(js *Json) (interface{}, error) {
-		bs, _ := js.Get("balances").Array()
+		bs, err := toArray(js.Get("balances").Interface())
+		if err != nil {
+			return nil, err
+		}
 		for _, b := range bs {
-			bt := b.(map[string]interface{})
-			if bt["free"].(string) != "0.00000000" {
+			bt, err := toMap(b)
+			if err != nil {
+				return nil, err
+			}
+			asset, err := toString(bt["asset"])
+			if err != nil {
+				return nil, err
+			}
+			free, err := toString(bt["free"])
+			if err != nil {
+				return nil, err
+			}
+			if f, err := toFloat(free); err != nil {
+				return nil, err
+			} else if f != 0 {
 				balances = append(balances,
-					Balance{Currency: bt["asset"].(string),
-						Balance: bt["free"].(string)})
+					Balance{Currency: asset, Balance: free})
 			}
 		}
 		return balances, nil
@@ -103,9 +121,10 @@ func (bn *Binance) GetPrice(cp *CurrencyPair) (price Price, err error) {
 	}
 
 	respOk := func(js *Json) (interface{}, error) {
-		price_s, _ := js.Get("price").String()
-		price, _ := strconv.ParseFloat(price_s, 64)
-
+		price, err := getFloat(js, "price")
+		if err != nil {
+			return nil, err
+		}
 		return Price{price}, nil
 	}
 
@@ -124,12 +143,24 @@ func (bn *Binance) GetSymbols() (symbols []string, err error) {
 
 	respOk := func(js *Json) (interface{}, error) {
 		var s []string
-		data, _ := js.Get("symbols").Array()
+		data, err := toArray(js.Get("symbols").Interface())
+		if err != nil {
+			return nil, err
+		}
 		for _, d := range data {
-			dd := d.(map[string]interface{})
-			base := strings.ToLower(dd["baseAsset"].(string))
-			quote := strings.ToLower(dd["quoteAsset"].(string))
-			s = append(s, base+"_"+quote)
+			dd, err := toMap(d)
+			if err != nil {
+				return nil, err
+			}
+			base, err := toString(dd["baseAsset"])
+			if err != nil {
+				return nil, err
+			}
+			quote, err := toString(dd["quoteAsset"])
+			if err != nil {
+				return nil, err
+			}
+			s = append(s, strings.ToLower(base+"_"+quote))
 		}
 		return s, nil
 	}
@@ -151,20 +182,9 @@ func (bn *Binance) GetDepth(cp *CurrencyPair) (depth Depth, err error) {
 	}
 
 	respOk := func(js *Json) (interface{}, error) {
-		var depth Depth
-		asks, _ := js.Get("asks").Array()
-		for _, a := range asks {
-			uu := a.([]interface{})
-			price, _ := strconv.ParseFloat(uu[0].(string), 64)
-			amount, _ := strconv.ParseFloat(uu[1].(string), 64)
-			depth.Asks = append([]Unit{Unit{price, amount}}, depth.Asks...)
-		}
-		bids, _ := js.Get("bids").Array()
-		for _, b := range bids {
-			uu := b.([]interface{})
-			price, _ := strconv.ParseFloat(uu[0].(string), 64)
-			amount, _ := strconv.ParseFloat(uu[1].(string), 64)
-			depth.Bids = append(depth.Bids, Unit{price, amount})
+		depth, err := getDepth(js, "asks", "bids")
+		if err != nil {
+			return nil, err
 		}
 		return depth, nil
 	}
@@ -177,12 +197,15 @@ func (bn *Binance) GetDepth(cp *CurrencyPair) (depth Depth, err error) {
 }
 
 func (bn *Binance) OrderState(s interface{}) string {
-	if s.(string) == "NEW" {
+	switch s {
+	case "NEW", "PARTIALLY_FILLED":
 		return Alive
-	} else if s.(string) == "CANCELED" {
+	case "CANCELED", "PENDING_CANCEL", "REJECTED", "EXPIRED":
 		return Cancelled
+	case "FILLED":
+		return Filled
 	}
-	return s.(string)
+	return Unknown
 }
 
 func (bn *Binance) OrderSide(s string) string {
@@ -205,8 +228,7 @@ func (bn *Binance) NewOrder(o *Order) (id string, err error) {
 	}
 
 	respOk := func(js *Json) (interface{}, error) {
-		id, _ = js.Get("clientOrderId").String()
-		return id, nil
+		return getString(js, "clientOrderId")
 	}
 
 	oid, err := ProcessResp(status, js, respOk, bn.respErr)
@@ -248,19 +270,34 @@ func (bn *Binance) QueryOrder(o *Order) (order Order, err error) {
 
 	respOk := func(js *Json) (interface{}, error) {
 		var order Order
-		order.Id, _ = js.Get("clientOrderId").String()
-		symbol, _ := js.Get("symbol").String()
+		var err error
+		if order.Id, err = getString(js, "clientOrderId"); err != nil {
+			return nil, err
+		}
+		symbol, err := getString(js, "symbol")
+		if err != nil {
+			return nil, err
+		}
 		order.CP = NewCurrencyPair2(bn.NormSymbol(&symbol))
-		side, _ := js.Get("side").String()
+		side, err := getString(js, "side")
+		if err != nil {
+			return nil, err
+		}
 		order.Side = bn.OrderSide(side)
-		price, _ := js.Get("price").String()
-		order.Price, _ = strconv.ParseFloat(price, 64)
-		amount, _ := js.Get("origQty").String()
-		order.Amount, _ = strconv.ParseFloat(amount, 64)
-		state, _ := js.Get("status").String()
+		if order.Price, err = getFloat(js, "price"); err != nil {
+			return nil, err
+		}
+		if order.Amount, err = getFloat(js, "origQty"); err != nil {
+			return nil, err
+		}
+		state, err := getString(js, "status")
+		if err != nil {
+			return nil, err
+		}
 		order.State = bn.OrderState(state)
-		executed, _ := js.Get("executedQty").String()
-		order.Executed, _ = strconv.ParseFloat(executed, 64)
+		if order.Executed, err = getFloat(js, "executedQty"); err != nil {
+			return nil, err
+		}
 		order.Remain = order.Amount - order.Executed
 
 		return order, nil
@@ -273,6 +310,13 @@ func (bn *Binance) QueryOrder(o *Order) (order Order, err error) {
 	return
 }
 
+func (bn *Binance) Capabilities() Capabilities {
+	return Capabilities{
+		Operations: BasicOperations,
+		OrderTypes: []string{LimitOrder},
+	}
+}
+
 func NewBinance() Exchange {
 	return new(Binance)
 }
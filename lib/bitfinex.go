@@ -33,7 +33,10 @@ func (bf *Bitfinex) ToSymbol(cp *CurrencyPair) string {
 }
 
 func (bf *Bitfinex) NormSymbol(cp *string) string {
-	tmp := *cp
+	tmp := strings.ToLower(*cp)
+	if len(tmp) < 6 {
+		return tmp
+	}
 	return tmp[:3] + "_" + tmp[3:]
 }
 
@@ -80,12 +83,28 @@ func (bf *Bitfinex) GetBalance() (balances []Balance, err error) {
 	}
 
 	respOk := func(js *Json) (interface{}, error) {
-		bs, _ := js.Array()
+		bs, err := js.Array()
+		if err != nil {
+			return nil, err
+		}
 		for _, b := range bs {
-			bt := b.(map[string]interface{})
+			bt, err := toMap(b)
+			if err != nil {
+				return nil, err
+			}
+			currency, err := toString(bt["currency"])
+			if err != nil {
+				return nil, err
+			}
+			amount, err := toString(bt["amount"])
+			if err != nil {
+				return nil, err
+			}
+			if _, err = toFloat(amount); err != nil {
+				return nil, err
+			}
 			balances = append(balances,
-				Balance{Currency: bt["currency"].(string),
-					Balance: bt["amount"].(string)})
+				Balance{Currency: currency, Balance: amount})
 		}
 		return balances, nil
 	}
@@ -109,8 +128,10 @@ func (bf *Bitfinex) GetPrice(cp *CurrencyPair) (price Price, err error) {
 	}
 
 	respOk := func(js *Json) (interface{}, error) {
-		price_s, _ := js.Get("last_price").String()
-		price, _ := strconv.ParseFloat(price_s, 64)
+		price, err := getFloat(js, "last_price")
+		if err != nil {
+			return nil, err
+		}
 		return Price{price}, nil
 	}
 
@@ -129,11 +150,16 @@ func (bf *Bitfinex) GetSymbols() (symbols []string, err error) {
 
 	respOk := func(js *Json) (interface{}, error) {
 		var s []string
-		data, _ := js.Array()
+		data, err := js.Array()
+		if err != nil {
+			return nil, err
+		}
 		for _, d := range data {
-			base := d.(string)[0:3]
-			quote := d.(string)[3:]
-			s = append(s, base+"_"+quote)
+			symbol, err := toString(d)
+			if err != nil {
+				return nil, err
+			}
+			s = append(s, bf.NormSymbol(&symbol))
 		}
 		return s, nil
 	}
@@ -153,19 +179,12 @@ func (bf *Bitfinex) GetDepth(cp *CurrencyPair) (depth Depth, err error) {
 
 	respOk := func(js *Json) (interface{}, error) {
 		var depth Depth
-		asks, _ := js.Get("asks").Array()
-		for _, a := range asks {
-			uu := a.(map[string]interface{})
-			price, _ := strconv.ParseFloat(uu["price"].(string), 64)
-			amount, _ := strconv.ParseFloat(uu["amount"].(string), 64)
-			depth.Asks = append([]Unit{Unit{price, amount}}, depth.Asks...)
+		var err error
+		if depth.Asks, err = toUnitsOf(js.Get("asks").Interface(), "price", "amount"); err != nil {
+			return nil, err
 		}
-		bids, _ := js.Get("bids").Array()
-		for _, b := range bids {
-			uu := b.(map[string]interface{})
-			price, _ := strconv.ParseFloat(uu["price"].(string), 64)
-			amount, _ := strconv.ParseFloat(uu["amount"].(string), 64)
-			depth.Bids = append(depth.Bids, Unit{price, amount})
+		if depth.Bids, err = toUnitsOf(js.Get("bids").Interface(), "price", "amount"); err != nil {
+			return nil, err
 		}
 		return depth, nil
 	}
@@ -178,7 +197,7 @@ func (bf *Bitfinex) GetDepth(cp *CurrencyPair) (depth Depth, err error) {
 }
 
 func (bf *Bitfinex) OrderState(s interface{}) string {
-	if s.(bool) {
+	if cancelled, _ := s.(bool); cancelled {
 		return Cancelled
 	}
 	return Alive
@@ -204,7 +223,10 @@ func (bf *Bitfinex) NewOrder(o *Order) (id string, err error) {
 	}
 
 	respOk := func(js *Json) (interface{}, error) {
-		id, _ := js.Get("id").Int64()
+		id, err := js.Get("id").Int64()
+		if err != nil {
+			return nil, errors.New("No order id")
+		}
 		return strconv.FormatInt(id, 10), nil
 	}
 
@@ -247,22 +269,45 @@ func (bf *Bitfinex) QueryOrder(o *Order) (order Order, err error) {
 
 	respOk := func(js *Json) (interface{}, error) {
 		var order Order
-		id, _ := js.Get("id").Int64()
+		id, err := js.Get("id").Int64()
+		if err != nil {
+			return nil, errors.New("No order " + o.Id)
+		}
 		order.Id = strconv.FormatInt(id, 10)
-		symbol, _ := js.Get("symbol").String()
+		symbol, err := getString(js, "symbol")
+		if err != nil {
+			return nil, err
+		}
 		order.CP = NewCurrencyPair2(bf.NormSymbol(&symbol))
-		side, _ := js.Get("side").String()
+		side, err := getString(js, "side")
+		if err != nil {
+			return nil, err
+		}
 		order.Side = bf.OrderSide(side)
-		price, _ := js.Get("price").String()
-		order.Price, _ = strconv.ParseFloat(price, 64)
-		amount, _ := js.Get("original_amount").String()
-		order.Amount, _ = strconv.ParseFloat(amount, 64)
-		cancelled, _ := js.Get("is_cancelled").Bool()
+		if order.Price, err = getFloat(js, "price"); err != nil {
+			return nil, err
+		}
+		if order.Amount, err = getFloat(js, "original_amount"); err != nil {
+			return nil, err
+		}
+		cancelled, err := js.Get("is_cancelled").Bool()
+		if err != nil {
+			return nil, errors.New("No is_cancelled in response")
+		}
 		order.State = bf.OrderState(cancelled)
-		remain, _ := js.Get("remaining_amount").String()
-		order.Remain, _ = strconv.ParseFloat(remain, 64)
-		executed, _ := js.Get("executed_amount").String()
-		order.Executed, _ = strconv.ParseFloat(executed, 64)
+		if order.Remain, err = getFloat(js, "remaining_amount"); err != nil {
+			return nil, err
+		}
+		if order.Executed, err = getFloat(js, "executed_amount"); err != nil {
+			return nil, err
+		}
+		live, err := js.Get("is_live").Bool()
+		if err != nil {
+			return nil, errors.New("No is_live in response")
+		}
+		if !live && !cancelled {
+			order.State = Filled
+		}
 
 		return order, nil
 	}
@@ -274,6 +319,13 @@ func (bf *Bitfinex) QueryOrder(o *Order) (order Order, err error) {
 	return
 }
 
+func (bf *Bitfinex) Capabilities() Capabilities {
+	return Capabilities{
+		Operations: BasicOperations,
+		OrderTypes: []string{LimitOrder},
+	}
+}
+
 func NewBitfinex() Exchange {
 	return new(Bitfinex)
 }
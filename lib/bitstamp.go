@@ -1,20 +1,57 @@
 package lib
 
 import (
+	"errors"
+	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	. "github.com/bitly/go-simplejson"
 )
 
+/*
+ * Reference page: https://www.bitstamp.net/api/
+ *
+ * Private calls are signed with the customer id beside the access and
+ * secret key, which are set by SetCredentials.
+ */
+
 type BitStamp struct {
 	accesskeyid, secretkeyid string
+	customerid               string
 }
 
 func (bs *BitStamp) respErr(js *Json) (interface{}, error) {
-	return nil, nil
+	if reason, err := js.Get("reason").String(); err == nil {
+		return nil, errors.New(reason)
+	}
+	// reason of a rejected order is a map of field to messages
+	if reasons, err := js.Get("reason").Map(); err == nil {
+		var msgs []string
+		for field, r := range reasons {
+			msgs = append(msgs, fmt.Sprintf("%s: %v", field, r))
+		}
+		sort.Strings(msgs)
+		return nil, errors.New(strings.Join(msgs, ", "))
+	}
+	if reason, err := js.Get("error").String(); err == nil {
+		return nil, errors.New(reason)
+	}
+	return nil, errors.New(Unknown)
+}
+
+// failed reports errors Bitstamp returns with status 200
+func (bs *BitStamp) failed(js *Json) bool {
+	if status, _ := js.Get("status").String(); status == "error" {
+		return true
+	}
+	_, ok := js.CheckGet("error")
+	return ok
 }
 
 func (bs *BitStamp) ToSymbol(cp *CurrencyPair) string {
@@ -22,7 +59,7 @@ func (bs *BitStamp) ToSymbol(cp *CurrencyPair) string {
 }
 
 func (bs *BitStamp) NormSymbol(cp *string) string {
-	return *cp
+	return strings.Replace(strings.ToLower(*cp), "/", "_", 1)
 }
 
 func (bs *BitStamp) sendReq(method, path string,
@@ -38,19 +75,19 @@ func (bs *BitStamp) sendReq(method, path string,
 
 	req.URL, _ = url.Parse("https://www.bitstamp.net" + path)
 	if sign {
-		sign_params := map[string][]string{
-			"ex_key": {bs.accesskeyid},
+		nonce := strconv.FormatInt(time.Now().UnixNano(), 10)
+		message := nonce + bs.customerid + bs.accesskeyid
+		q := url.Values{
+			"key":       {bs.accesskeyid},
+			"nonce":     {nonce},
+			"signature": {strings.ToUpper(ComputeHmac256(message, bs.secretkeyid))},
 		}
-
 		for k, v := range params {
-			sign_params[k] = v
+			q[k] = v
 		}
-
-		q := req.URL.Query()
-		q = sign_params
-		data := q.Encode() + "&secret_key=" + bs.secretkeyid
-		q.Add("sign", strings.ToUpper(GetMD5Hash(data)))
-		req.URL.RawQuery = q.Encode()
+		data := q.Encode()
+		req.Body = ioutil.NopCloser(strings.NewReader(data))
+		req.ContentLength = int64(len(data))
 	} else {
 		q := req.URL.Query()
 		q = params
@@ -64,11 +101,79 @@ func (bs *BitStamp) SetKey(access, secret string) {
 	bs.secretkeyid = secret
 }
 
+func (bs *BitStamp) SetCredentials(c Credentials) error {
+	bs.SetKey(c[CredAccess], c[CredSecret])
+	bs.customerid = c[CredCustomerId]
+	return nil
+}
+
 func (bs *BitStamp) GetBalance() (balances []Balance, err error) {
+	status, js, err := bs.sendReq("POST", "/api/v2/balance/", nil, true)
+	if err != nil {
+		return
+	}
+
+	respOk := func(js *Json) (interface{}, error) {
+		if bs.failed(js) {
+			return bs.respErr(js)
+		}
+
+		var balances []Balance
+		data, err := js.Map()
+		if err != nil {
+			return nil, err
+		}
+		for k, b := range data {
+			if !strings.HasSuffix(k, "_balance") {
+				continue
+			}
+			balance, err := toString(b)
+			if err != nil {
+				return nil, err
+			}
+			if _, err = toFloat(balance); err != nil {
+				return nil, err
+			}
+			balances = append(balances,
+				Balance{Currency: strings.TrimSuffix(k, "_balance"),
+					Balance: balance})
+		}
+		// every currency is listed, even without balance
+		if len(balances) == 0 {
+			return nil, errors.New("No balances in response")
+		}
+		return balances, nil
+	}
+
+	b, err := ProcessResp(status, js, respOk, bs.respErr)
+	if err == nil {
+		balances = b.([]Balance)
+	}
 	return
 }
 
 func (bs *BitStamp) GetPrice(cp *CurrencyPair) (price Price, err error) {
+	status, js, err := bs.sendReq("GET", "/api/v2/ticker/"+bs.ToSymbol(cp)+"/", nil, false)
+	if err != nil {
+		return
+	}
+
+	respOk := func(js *Json) (interface{}, error) {
+		if bs.failed(js) {
+			return bs.respErr(js)
+		}
+
+		price, err := getFloat(js, "last")
+		if err != nil {
+			return nil, err
+		}
+		return Price{price}, nil
+	}
+
+	p, err := ProcessResp(status, js, respOk, bs.respErr)
+	if err == nil {
+		price = p.(Price)
+	}
 	return
 }
 
@@ -80,11 +185,20 @@ func (bs *BitStamp) GetSymbols() (symbols []string, err error) {
 
 	respOk := func(js *Json) (interface{}, error) {
 		var s []string
-		data, _ := js.Array()
+		data, err := js.Array()
+		if err != nil {
+			return nil, err
+		}
 		for _, d := range data {
-			dd := d.(map[string]interface{})
-			raw := strings.ToLower(dd["name"].(string))
-			s = append(s, strings.Replace(raw, "/", "_", 1))
+			dd, err := toMap(d)
+			if err != nil {
+				return nil, err
+			}
+			name, err := toString(dd["name"])
+			if err != nil {
+				return nil, err
+			}
+			s = append(s, bs.NormSymbol(&name))
 		}
 		return s, nil
 	}
@@ -103,20 +217,9 @@ func (bs *BitStamp) GetDepth(cp *CurrencyPair) (depth Depth, err error) {
 	}
 
 	respOk := func(js *Json) (interface{}, error) {
-		var depth Depth
-		asks, _ := js.Get("asks").Array()
-		for _, a := range asks {
-			uu := a.([]interface{})
-			price, _ := strconv.ParseFloat(uu[0].(string), 64)
-			amount, _ := strconv.ParseFloat(uu[1].(string), 64)
-			depth.Asks = append([]Unit{Unit{price, amount}}, depth.Asks...)
-		}
-		bids, _ := js.Get("bids").Array()
-		for _, b := range bids {
-			uu := b.([]interface{})
-			price, _ := strconv.ParseFloat(uu[0].(string), 64)
-			amount, _ := strconv.ParseFloat(uu[1].(string), 64)
-			depth.Bids = append(depth.Bids, Unit{price, amount})
+		depth, err := getDepth(js, "asks", "bids")
+		if err != nil {
+			return nil, err
 		}
 		return depth, nil
 	}
@@ -129,29 +232,169 @@ func (bs *BitStamp) GetDepth(cp *CurrencyPair) (depth Depth, err error) {
 }
 
 func (bs *BitStamp) OrderState(s interface{}) string {
-	return s.(string)
+	switch s {
+	case "Open", "In Queue":
+		return Alive
+	case "Canceled":
+		return Cancelled
+	case "Finished":
+		return Filled
+	}
+	return Unknown
 }
 
+// OrderSide converts type of an order, 0 is buy and 1 is sell
 func (bs *BitStamp) OrderSide(s string) string {
+	switch s {
+	case "0":
+		return "buy"
+	case "1":
+		return "sell"
+	}
 	return s
 }
 
+// NewOrder places a limit order, or a market one if o.Price is 0
 func (bs *BitStamp) NewOrder(o *Order) (id string, err error) {
+	params := map[string][]string{
+		"amount": {strconv.FormatFloat(o.Amount, 'f', -1, 64)},
+	}
+	path := "/api/v2/" + o.Side + "/"
+	if o.Price == 0 {
+		path += "market/"
+	} else {
+		params["price"] = []string{strconv.FormatFloat(o.Price, 'f', -1, 64)}
+	}
+	path += bs.ToSymbol(&o.CP) + "/"
+
+	status, js, err := bs.sendReq("POST", path, params, true)
+	if err != nil {
+		return
+	}
+
+	respOk := func(js *Json) (interface{}, error) {
+		if bs.failed(js) {
+			return bs.respErr(js)
+		}
+
+		return getId(js, "id")
+	}
+
+	oid, err := ProcessResp(status, js, respOk, bs.respErr)
+	if err == nil {
+		id = oid.(string)
+	}
 	return
 }
 
 func (bs *BitStamp) CancelOrder(o *Order) (err error) {
+	params := map[string][]string{
+		"id": {o.Id},
+	}
+
+	status, js, err := bs.sendReq("POST", "/api/v2/cancel_order/", params, true)
+	if err != nil {
+		return
+	}
+
+	respOk := func(js *Json) (interface{}, error) {
+		if bs.failed(js) {
+			return bs.respErr(js)
+		}
+		return nil, nil
+	}
+
+	_, err = ProcessResp(status, js, respOk, bs.respErr)
 	return
 }
 
+// QueryOrder works out the price and amount from the transactions, as
+// order_status doesn't report them
 func (bs *BitStamp) QueryOrder(o *Order) (order Order, err error) {
+	params := map[string][]string{
+		"id": {o.Id},
+	}
+
+	status, js, err := bs.sendReq("POST", "/api/v2/order_status/", params, true)
+	if err != nil {
+		return
+	}
+
+	respOk := func(js *Json) (interface{}, error) {
+		if bs.failed(js) {
+			return bs.respErr(js)
+		}
+
+		var order Order
+		order.Id = o.Id
+		order.CP = o.CP
+		if market, err := js.Get("market").String(); err == nil {
+			order.CP = NewCurrencyPair2(bs.NormSymbol(&market))
+		}
+		order.Side = o.Side
+		if side, err := js.Get("type").String(); err == nil {
+			order.Side = bs.OrderSide(side)
+		}
+		base := strings.ToLower(order.CP.CurrencyA.Symbol)
+		quote := strings.ToLower(order.CP.CurrencyB.Symbol)
+		var cost float64
+		txs, err := toArray(js.Get("transactions").Interface())
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range txs {
+			tt, err := toMap(t)
+			if err != nil {
+				return nil, err
+			}
+			amount, err := toFloat(tt[base])
+			if err != nil {
+				return nil, err
+			}
+			total, err := toFloat(tt[quote])
+			if err != nil {
+				return nil, err
+			}
+			order.Executed += amount
+			cost += total
+		}
+		order.Price = o.Price
+		if order.Executed > 0 {
+			order.Price = cost / order.Executed
+		}
+		if order.Remain, err = getFloat(js, "amount_remaining"); err != nil {
+			return nil, err
+		}
+		order.Amount = order.Executed + order.Remain
+		state, err := getString(js, "status")
+		if err != nil {
+			return nil, err
+		}
+		order.State = bs.OrderState(state)
+		return order, nil
+	}
+
+	od, err := ProcessResp(status, js, respOk, bs.respErr)
+	if err == nil {
+		order = od.(Order)
+	}
 	return
 }
 
+func (bs *BitStamp) Capabilities() Capabilities {
+	return Capabilities{
+		Operations: BasicOperations,
+		OrderTypes: []string{LimitOrder, MarketOrder},
+	}
+}
+
 func NewBitStamp() Exchange {
 	return new(BitStamp)
 }
 
 func init() {
-	RegisterEx("bitstamp", NewBitStamp)
+	RegisterEx("bitstamp", NewBitStamp,
+		CredentialField{Name: CredAccess, Desc: "API key"},
+		CredentialField{Name: CredSecret, Desc: "API secret"},
+		CredentialField{Name: CredCustomerId, Desc: "Customer ID"})
 }
@@ -1,20 +1,44 @@
 package lib
 
 import (
+	"bytes"
+	"crypto/sha512"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	. "github.com/bitly/go-simplejson"
 )
 
+/*
+ * Reference page: https://bittrex.github.io/api/v1-1
+ *                 https://bittrex.github.io/api/v3
+ *
+ * Market data comes from v1.1, which names a market QUOTE-BASE, eth_btc is
+ * BTC-ETH. The account and orders come from v3, which signs with a hash of
+ * the content and names the same market ETH-BTC.
+ */
+
 type Bittrex struct {
 	accesskeyid, secretkeyid string
 }
 
 func (bt *Bittrex) respErr(js *Json) (interface{}, error) {
-	return nil, nil
+	reason, _ := js.Get("message").String()
+	if reason == "" {
+		// v3
+		reason, _ = js.Get("code").String()
+	}
+	if reason == "" {
+		reason = Unknown
+	}
+	return nil, errors.New(reason)
 }
 
 func (bt *Bittrex) ToSymbol(cp *CurrencyPair) string {
@@ -22,13 +46,27 @@ func (bt *Bittrex) ToSymbol(cp *CurrencyPair) string {
 }
 
 func (bt *Bittrex) NormSymbol(cp *string) string {
-	return *cp
+	currency := strings.Split(*cp, "-")
+	if len(currency) != 2 {
+		return strings.ToLower(*cp)
+	}
+	return strings.ToLower(currency[1] + "_" + currency[0])
+}
+
+// marketSymbol is the name of cp in v3
+func (bt *Bittrex) marketSymbol(cp *CurrencyPair) string {
+	return strings.ToUpper(cp.ToSymbol("-"))
+}
+
+func (bt *Bittrex) normMarketSymbol(cp *string) string {
+	return strings.ToLower(strings.Replace(*cp, "-", "_", 1))
 }
 
 func (bt *Bittrex) sendReq(method, path string,
-	params map[string][]string, sign bool) (int, *Json, error) {
+	params map[string][]string, body map[string]interface{}, sign bool) (int, *Json, error) {
 	header := map[string][]string{
-		"Content-Type": {`application/x-www-form-urlencoded`},
+		"Content-Type": {`application/json`},
+		"Accept":       {`application/json`},
 	}
 
 	req := &http.Request{
@@ -36,25 +74,32 @@ func (bt *Bittrex) sendReq(method, path string,
 		Header: header,
 	}
 
-	req.URL, _ = url.Parse("https://bittrex.com" + path)
 	if sign {
-		sign_params := map[string][]string{
-			"ex_key": {bt.accesskeyid},
-		}
+		req.URL, _ = url.Parse("https://api.bittrex.com" + path)
+	} else {
+		req.URL, _ = url.Parse("https://bittrex.com" + path)
+	}
+	q := req.URL.Query()
+	q = params
+	req.URL.RawQuery = q.Encode()
 
-		for k, v := range params {
-			sign_params[k] = v
-		}
+	var content []byte
+	if body != nil {
+		content, _ = json.Marshal(body)
+		req.Body = ioutil.NopCloser(bytes.NewBuffer(content))
+		req.ContentLength = int64(len(content))
+	}
 
-		q := req.URL.Query()
-		q = sign_params
-		data := q.Encode() + "&secret_key=" + bt.secretkeyid
-		q.Add("sign", strings.ToUpper(GetMD5Hash(data)))
-		req.URL.RawQuery = q.Encode()
-	} else {
-		q := req.URL.Query()
-		q = params
-		req.URL.RawQuery = q.Encode()
+	if sign {
+		timestamp := strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10)
+		hash := sha512.Sum512(content)
+		contentHash := hex.EncodeToString(hash[:])
+		data := timestamp + req.URL.String() + method + contentHash
+
+		req.Header.Add("Api-Key", bt.accesskeyid)
+		req.Header.Add("Api-Timestamp", timestamp)
+		req.Header.Add("Api-Content-Hash", contentHash)
+		req.Header.Add("Api-Signature", ComputeHmac512(data, bt.secretkeyid))
 	}
 	return recvResp(req)
 }
@@ -65,27 +110,104 @@ func (bt *Bittrex) SetKey(access, secret string) {
 }
 
 func (bt *Bittrex) GetBalance() (balances []Balance, err error) {
+	status, js, err := bt.sendReq("GET", "/v3/balances", nil, nil, true)
+	if err != nil {
+		return
+	}
+
+	respOk := func(js *Json) (interface{}, error) {
+		var balances []Balance
+		bs, err := js.Array()
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range bs {
+			bb, err := toMap(b)
+			if err != nil {
+				return nil, err
+			}
+			currency, err := toString(bb["currencySymbol"])
+			if err != nil {
+				return nil, err
+			}
+			total, err := toString(bb["total"])
+			if err != nil {
+				return nil, err
+			}
+			if _, err = toFloat(total); err != nil {
+				return nil, err
+			}
+			balances = append(balances,
+				Balance{Currency: strings.ToLower(currency), Balance: total})
+		}
+		return balances, nil
+	}
+
+	b, err := ProcessResp(status, js, respOk, bt.respErr)
+	if err == nil {
+		balances = b.([]Balance)
+	}
 	return
 }
 
 func (bt *Bittrex) GetPrice(cp *CurrencyPair) (price Price, err error) {
+	params := map[string][]string{
+		"market": {bt.ToSymbol(cp)},
+	}
+	status, js, err := bt.sendReq("GET", "/api/v1.1/public/getticker", params, nil, false)
+	if err != nil {
+		return
+	}
+
+	respOk := func(js *Json) (interface{}, error) {
+		if success, _ := js.Get("success").Bool(); !success {
+			return bt.respErr(js)
+		}
+
+		price, err := getFloat(js.Get("result"), "Last")
+		if err != nil {
+			return nil, err
+		}
+		return Price{price}, nil
+	}
+
+	p, err := ProcessResp(status, js, respOk, bt.respErr)
+	if err == nil {
+		price = p.(Price)
+	}
 	return
 }
 
 func (bt *Bittrex) GetSymbols() (symbols []string, err error) {
-	status, js, err := bt.sendReq("GET", "/api/v1.1/public/getmarkets", nil, false)
+	status, js, err := bt.sendReq("GET", "/api/v1.1/public/getmarkets", nil, nil, false)
 	if err != nil {
 		return
 	}
 
 	respOk := func(js *Json) (interface{}, error) {
+		if success, _ := js.Get("success").Bool(); !success {
+			return bt.respErr(js)
+		}
+
 		var s []string
-		data, _ := js.Get("result").Array()
+		data, err := toArray(js.Get("result").Interface())
+		if err != nil {
+			return nil, err
+		}
 		for _, d := range data {
-			dd := d.(map[string]interface{})
-			quote := strings.ToLower(dd["BaseCurrency"].(string))
-			base := strings.ToLower(dd["MarketCurrency"].(string))
-			s = append(s, base+"_"+quote)
+			dd, err := toMap(d)
+			if err != nil {
+				return nil, err
+			}
+			quote, err := toString(dd["BaseCurrency"])
+			if err != nil {
+				return nil, err
+			}
+			base, err := toString(dd["MarketCurrency"])
+			if err != nil {
+				return nil, err
+			}
+			s = append(s, strings.ToLower(base+"_"+quote))
 		}
 		return s, nil
 	}
@@ -102,26 +224,24 @@ func (bt *Bittrex) GetDepth(cp *CurrencyPair) (depth Depth, err error) {
 		"market": {bt.ToSymbol(cp)},
 		"type":   {"both"},
 	}
-	status, js, err := bt.sendReq("GET", "/api/v1.1/public/getorderbook", params, false)
+	status, js, err := bt.sendReq("GET", "/api/v1.1/public/getorderbook", params, nil, false)
 	if err != nil {
 		return
 	}
 
 	respOk := func(js *Json) (interface{}, error) {
+		if success, _ := js.Get("success").Bool(); !success {
+			return bt.respErr(js)
+		}
+
 		var depth Depth
-		asks, _ := js.Get("result").Get("sell").Array()
-		for _, a := range asks {
-			uu := a.(map[string]interface{})
-			price, _ := uu["Rate"].(json.Number).Float64()
-			amount, _ := uu["Quantity"].(json.Number).Float64()
-			depth.Asks = append([]Unit{Unit{price, amount}}, depth.Asks...)
-		}
-		bids, _ := js.Get("result").Get("buy").Array()
-		for _, b := range bids {
-			uu := b.(map[string]interface{})
-			price, _ := uu["Rate"].(json.Number).Float64()
-			amount, _ := uu["Quantity"].(json.Number).Float64()
-			depth.Bids = append(depth.Bids, Unit{price, amount})
+		var err error
+		result := js.Get("result")
+		if depth.Asks, err = toUnitsOf(result.Get("sell").Interface(), "Rate", "Quantity"); err != nil {
+			return nil, err
+		}
+		if depth.Bids, err = toUnitsOf(result.Get("buy").Interface(), "Rate", "Quantity"); err != nil {
+			return nil, err
 		}
 		return depth, nil
 	}
@@ -133,26 +253,119 @@ func (bt *Bittrex) GetDepth(cp *CurrencyPair) (depth Depth, err error) {
 	return
 }
 
+// OrderState maps a CLOSED order to Cancelled, QueryOrder tells a filled
+// one by its remain.
 func (bt *Bittrex) OrderState(s interface{}) string {
-	return s.(string)
+	switch s {
+	case "OPEN":
+		return Alive
+	case "CLOSED":
+		return Cancelled
+	}
+	return Unknown
 }
 
 func (bt *Bittrex) OrderSide(s string) string {
-	return s
+	return strings.ToLower(s)
 }
 
 func (bt *Bittrex) NewOrder(o *Order) (id string, err error) {
+	body := map[string]interface{}{
+		"marketSymbol": bt.marketSymbol(&o.CP),
+		"direction":    strings.ToUpper(o.Side),
+		"type":         "LIMIT",
+		"quantity":     strconv.FormatFloat(o.Amount, 'f', -1, 64),
+		"limit":        strconv.FormatFloat(o.Price, 'f', -1, 64),
+		"timeInForce":  "GOOD_TIL_CANCELLED",
+	}
+
+	status, js, err := bt.sendReq("POST", "/v3/orders", nil, body, true)
+	if err != nil {
+		return
+	}
+
+	respOk := func(js *Json) (interface{}, error) {
+		return getString(js, "id")
+	}
+
+	oid, err := ProcessResp(status, js, respOk, bt.respErr)
+	if err == nil {
+		id = oid.(string)
+	}
 	return
 }
 
 func (bt *Bittrex) CancelOrder(o *Order) (err error) {
+	status, js, err := bt.sendReq("DELETE", "/v3/orders/"+o.Id, nil, nil, true)
+	if err != nil {
+		return
+	}
+
+	respOk := func(js *Json) (interface{}, error) {
+		return nil, nil
+	}
+
+	_, err = ProcessResp(status, js, respOk, bt.respErr)
 	return
 }
 
 func (bt *Bittrex) QueryOrder(o *Order) (order Order, err error) {
+	status, js, err := bt.sendReq("GET", "/v3/orders/"+o.Id, nil, nil, true)
+	if err != nil {
+		return
+	}
+
+	respOk := func(js *Json) (interface{}, error) {
+		var order Order
+		var err error
+		if order.Id, err = getString(js, "id"); err != nil {
+			return nil, err
+		}
+		symbol, err := getString(js, "marketSymbol")
+		if err != nil {
+			return nil, err
+		}
+		order.CP = NewCurrencyPair2(bt.normMarketSymbol(&symbol))
+		side, err := getString(js, "direction")
+		if err != nil {
+			return nil, err
+		}
+		order.Side = bt.OrderSide(side)
+		if order.Price, err = getFloat(js, "limit"); err != nil {
+			return nil, err
+		}
+		if order.Amount, err = getFloat(js, "quantity"); err != nil {
+			return nil, err
+		}
+		if order.Executed, err = getFloat(js, "fillQuantity"); err != nil {
+			return nil, err
+		}
+		order.Remain = order.Amount - order.Executed
+		state, err := getString(js, "status")
+		if err != nil {
+			return nil, err
+		}
+		order.State = bt.OrderState(state)
+		if order.State == Cancelled && order.Remain == 0 {
+			order.State = Filled
+		}
+		return order, nil
+	}
+
+	od, err := ProcessResp(status, js, respOk, bt.respErr)
+	if err == nil {
+		order = od.(Order)
+	}
 	return
 }
 
+func (bt *Bittrex) Capabilities() Capabilities {
+	return Capabilities{
+		Operations: BasicOperations,
+		OrderTypes: []string{LimitOrder},
+	}
+}
+
 func NewBittrex() Exchange {
 	return new(Bittrex)
 }
@@ -0,0 +1,143 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"sync"
+)
+
+/*
+ * Cassette is a http.RoundTripper which records the responses of an
+ * exchange into a golden file, and replays them later in the same order
+ * without touching the network. Set it as Transport to use it.
+ *
+ * Only method, path, status and body are kept. Queries and headers carry
+ * the keys and signatures, so they never reach the file, and a replayed
+ * request is matched by method and path only.
+ */
+
+type Interaction struct {
+	Method string
+	Path   string
+	Status int
+	Body   json.RawMessage `json:",omitempty"`
+	Raw    string          `json:",omitempty"` // body which is not json
+}
+
+type Cassette struct {
+	Interactions []Interaction
+
+	mu   sync.Mutex
+	pos  int
+	next http.RoundTripper
+}
+
+// RecordCassette records what next, or http.DefaultTransport if nil,
+// receives.
+func RecordCassette(next http.RoundTripper) *Cassette {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &Cassette{next: next}
+}
+
+// NewCassette replays interactions.
+func NewCassette(interactions []Interaction) *Cassette {
+	return &Cassette{Interactions: interactions}
+}
+
+func LoadCassette(path string) (*Cassette, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var interactions []Interaction
+	if err = json.Unmarshal(raw, &interactions); err != nil {
+		return nil, errors.New(path + ": " + err.Error())
+	}
+	return NewCassette(interactions), nil
+}
+
+func (c *Cassette) Save(path string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	raw, err := json.MarshalIndent(c.Interactions, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(raw, '\n'), 0644)
+}
+
+// Done reports whether all interactions were replayed.
+func (c *Cassette) Done() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pos == len(c.Interactions)
+}
+
+func (c *Cassette) RoundTrip(req *http.Request) (*http.Response, error) {
+	if c.next != nil {
+		return c.record(req)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pos >= len(c.Interactions) {
+		return nil, errors.New("cassette: unexpected request " +
+			req.Method + " " + req.URL.Path)
+	}
+	it := c.Interactions[c.pos]
+	if it.Method != req.Method || it.Path != req.URL.Path {
+		return nil, errors.New("cassette: request " + req.Method + " " +
+			req.URL.Path + ", recorded " + it.Method + " " + it.Path)
+	}
+	c.pos++
+
+	body := []byte(it.Raw)
+	if it.Body != nil {
+		body = it.Body
+	}
+	return newResponse(req, it.Status, body), nil
+}
+
+func (c *Cassette) record(req *http.Request) (*http.Response, error) {
+	resp, err := c.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	it := Interaction{Method: req.Method, Path: req.URL.Path, Status: resp.StatusCode}
+	if json.Valid(body) {
+		it.Body = json.RawMessage(body)
+	} else {
+		it.Raw = string(body)
+	}
+	c.mu.Lock()
+	c.Interactions = append(c.Interactions, it)
+	c.mu.Unlock()
+
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+func newResponse(req *http.Request, status int, body []byte) *http.Response {
+	return &http.Response{
+		Status:        http.StatusText(status),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"application/json"}},
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
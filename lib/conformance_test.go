@@ -0,0 +1,33 @@
+package lib_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/RichardWeiYang/bcex/conformance"
+	"github.com/RichardWeiYang/bcex/lib"
+)
+
+// TestConformance runs the golden files of each exchange through the
+// conformance kit.
+func TestConformance(t *testing.T) {
+	dirs, _ := filepath.Glob(filepath.Join("testdata", "*"))
+	if len(dirs) == 0 {
+		t.Fatal("no golden files in testdata")
+	}
+	for _, dir := range dirs {
+		name := filepath.Base(dir)
+		cases, err := conformance.LoadCases(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lib.GetEx(name) == nil {
+			t.Fatalf("exchange %s is not registered", name)
+		}
+		t.Run(name, func(t *testing.T) {
+			conformance.Run(t, func() lib.Exchange {
+				return lib.GetEx(name)
+			}, lib.NewCurrencyPair2("eth_btc"), cases)
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package lib
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+ * Credentials an exchange signs with are declared by name when it is
+ * registered, most take the access and secret key of DefaultCredentials.
+ * Exchanges with other credentials implement CredentialSetter, the rest get
+ * the access and secret key through SetKey.
+ */
+
+// Names of credential fields
+const (
+	CredAccess     = "access"
+	CredSecret     = "secret"
+	CredCustomerId = "customer-id"
+	CredDeviceId   = "device-id"
+)
+
+// CredentialField is a credential an exchange signs with, a Named one is
+// only given as NAME=VALUE and never takes a value given in order.
+type CredentialField struct {
+	Name     string
+	Desc     string
+	Optional bool
+	Named    bool
+}
+
+// Credentials holds the value of each credential field by its name
+type Credentials map[string]string
+
+// DefaultCredentials is the schema of exchanges registered without one
+var DefaultCredentials = []CredentialField{
+	{Name: CredAccess, Desc: "API access key"},
+	{Name: CredSecret, Desc: "API secret key"},
+}
+
+// CredentialSetter is implemented by exchanges whose credentials are not
+// just the access and secret key.
+type CredentialSetter interface {
+	SetCredentials(c Credentials) error
+}
+
+var credentials = map[string][]CredentialField{}
+
+// CredentialsOf returns the credential schema of exchange name, a wrapped
+// exchange takes the one of the exchange it wraps.
+func CredentialsOf(name string) []CredentialField {
+	name = name[strings.LastIndex(name, ":")+1:]
+	if _, ok := exs[name]; !ok {
+		return nil
+	}
+	if fields, ok := credentials[name]; ok {
+		return fields
+	}
+	return DefaultCredentials
+}
+
+// ValidateCredentials checks c has every field exchange name needs, and
+// nothing else.
+func ValidateCredentials(name string, c Credentials) error {
+	fields := CredentialsOf(name)
+	if fields == nil {
+		return fmt.Errorf("Exchange %s is not found", name)
+	}
+
+	known := map[string]bool{}
+	for _, f := range fields {
+		known[f.Name] = true
+		if !f.Optional && c[f.Name] == "" {
+			return fmt.Errorf("%s needs credential %s(%s)", name, f.Name, f.Desc)
+		}
+	}
+	for n := range c {
+		if !known[n] {
+			return fmt.Errorf("%s has no credential %s", name, n)
+		}
+	}
+	return nil
+}
+
+// ApplyCredentials gives c to ex
+func ApplyCredentials(ex Exchange, c Credentials) error {
+	if cs, ok := ex.(CredentialSetter); ok {
+		return cs.SetCredentials(c)
+	}
+	ex.SetKey(c[CredAccess], c[CredSecret])
+	return nil
+}
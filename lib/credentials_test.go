@@ -0,0 +1,25 @@
+package lib
+
+import "testing"
+
+func TestValidateCredentials(t *testing.T) {
+	cases := []struct {
+		name string
+		c    Credentials
+		ok   bool
+	}{
+		{"binance", Credentials{CredAccess: "a", CredSecret: "s"}, true},
+		{"binance", Credentials{CredAccess: "a"}, false},
+		{"binance", Credentials{CredAccess: "a", CredSecret: "s", CredCustomerId: "c"}, false},
+		{"bitstamp", Credentials{CredAccess: "a", CredSecret: "s"}, false},
+		{"bitstamp", Credentials{CredAccess: "a", CredSecret: "s", CredCustomerId: "c"}, true},
+		{"bigone", Credentials{CredAccess: "a"}, true},
+		{"paper:bitstamp", Credentials{CredAccess: "a", CredSecret: "s", CredCustomerId: "c"}, true},
+		{"nope", Credentials{CredAccess: "a", CredSecret: "s"}, false},
+	}
+	for _, c := range cases {
+		if err := ValidateCredentials(c.name, c.c); (err == nil) != c.ok {
+			t.Errorf("ValidateCredentials(%s, %v) = %v", c.name, c.c, err)
+		}
+	}
+}
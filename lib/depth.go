@@ -0,0 +1,84 @@
+package lib
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Mid returns the middle of the best ask and bid, 0 if a side is empty
+func (d Depth) Mid() float64 {
+	if len(d.Asks) == 0 || len(d.Bids) == 0 {
+		return 0
+	}
+	return (d.Asks[0].Price + d.Bids[0].Price) / 2
+}
+
+// Spread returns the best ask minus the best bid, 0 if a side is empty
+func (d Depth) Spread() float64 {
+	if len(d.Asks) == 0 || len(d.Bids) == 0 {
+		return 0
+	}
+	return d.Asks[0].Price - d.Bids[0].Price
+}
+
+// Top returns the best n levels of each side
+func (d Depth) Top(n int) Depth {
+	if n < len(d.Asks) {
+		d.Asks = d.Asks[:n]
+	}
+	if n < len(d.Bids) {
+		d.Bids = d.Bids[:n]
+	}
+	return d
+}
+
+// Group merges the levels into buckets of step in price, asks are rounded
+// up and bids down, so a bucket never looks better than its levels.
+func (d Depth) Group(step float64) Depth {
+	if step <= 0 {
+		return d
+	}
+	return Depth{
+		Asks: group(d.Asks, step, math.Ceil),
+		Bids: group(d.Bids, step, math.Floor),
+	}
+}
+
+func group(units []Unit, step float64, round func(float64) float64) []Unit {
+	// buckets have no more decimals than step
+	decimals := 0
+	if s := strconv.FormatFloat(step, 'f', -1, 64); strings.Contains(s, ".") {
+		decimals = len(s) - strings.Index(s, ".") - 1
+	}
+
+	var grouped []Unit
+	for _, u := range units {
+		// forgive the error of the division, 0.3/0.1 is 2.9999999999999996
+		k := math.Round(u.Price / step)
+		if math.Abs(u.Price/step-k) > 1e-9 {
+			k = round(u.Price / step)
+		}
+		price, _ := strconv.ParseFloat(strconv.FormatFloat(k*step, 'f', decimals, 64), 64)
+
+		if n := len(grouped); n > 0 && grouped[n-1].Price == price {
+			grouped[n-1].Amount += u.Amount
+		} else {
+			grouped = append(grouped, Unit{Price: price, Amount: u.Amount})
+		}
+	}
+	return grouped
+}
+
+// Cumulative returns the running sums of the amount and the notional,
+// price times amount, of units from the best one.
+func Cumulative(units []Unit) (amount, notional []float64) {
+	var a, n float64
+	for _, u := range units {
+		a += u.Amount
+		n += u.Price * u.Amount
+		amount = append(amount, a)
+		notional = append(notional, n)
+	}
+	return
+}
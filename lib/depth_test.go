@@ -0,0 +1,48 @@
+package lib
+
+import (
+	"reflect"
+	"testing"
+)
+
+var testDepth = Depth{
+	Asks: []Unit{{0.31, 1}, {0.34, 2}, {0.4, 1}, {0.45, 3}},
+	Bids: []Unit{{0.3, 2}, {0.29, 1}, {0.21, 4}, {0.2, 1}},
+}
+
+func TestDepthSummary(t *testing.T) {
+	if mid := testDepth.Mid(); mid != 0.305 {
+		t.Errorf("Mid = %v", mid)
+	}
+	if spread := testDepth.Spread(); spread < 0.0099 || spread > 0.0101 {
+		t.Errorf("Spread = %v", spread)
+	}
+	if mid := (Depth{Asks: testDepth.Asks}).Mid(); mid != 0 {
+		t.Errorf("Mid without bids = %v", mid)
+	}
+
+	top := testDepth.Top(2)
+	if len(top.Asks) != 2 || len(top.Bids) != 2 || top.Bids[1].Price != 0.29 {
+		t.Errorf("Top(2) = %v", top)
+	}
+}
+
+func TestDepthGroup(t *testing.T) {
+	want := Depth{
+		Asks: []Unit{{0.4, 4}, {0.5, 3}},
+		Bids: []Unit{{0.3, 2}, {0.2, 6}},
+	}
+	if got := testDepth.Group(0.1); !reflect.DeepEqual(got, want) {
+		t.Errorf("Group(0.1) = %v, want %v", got, want)
+	}
+	if got := testDepth.Group(0); !reflect.DeepEqual(got, testDepth) {
+		t.Errorf("Group(0) = %v", got)
+	}
+}
+
+func TestCumulative(t *testing.T) {
+	amount, notional := Cumulative([]Unit{{0.5, 2}, {0.25, 4}})
+	if !reflect.DeepEqual(amount, []float64{2, 6}) || !reflect.DeepEqual(notional, []float64{1, 2}) {
+		t.Errorf("Cumulative = %v, %v", amount, notional)
+	}
+}
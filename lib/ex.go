@@ -1,6 +1,7 @@
 package lib
 
 import (
+	"errors"
 	"net/http"
 	"net/url"
 	"strings"
@@ -8,12 +9,17 @@ import (
 	. "github.com/bitly/go-simplejson"
 )
 
+/*
+ * Ex is the template of a new exchange, a method not supported yet returns
+ * ErrNotImplemented.
+ */
+
 type Ex struct {
 	accesskeyid, secretkeyid string
 }
 
 func (exe *Ex) respErr(js *Json) (interface{}, error) {
-	return nil, nil
+	return nil, errors.New(Unknown)
 }
 
 func (exe *Ex) ToSymbol(cp *CurrencyPair) string {
@@ -64,23 +70,26 @@ func (exe *Ex) SetKey(access, secret string) {
 }
 
 func (exe *Ex) GetBalance() (balances []Balance, err error) {
-	return
+	return nil, ErrNotImplemented
 }
 
 func (exe *Ex) GetPrice(cp *CurrencyPair) (price Price, err error) {
-	return
+	return price, ErrNotImplemented
 }
 
 func (exe *Ex) GetSymbols() (symbols []string, err error) {
-	return
+	return nil, ErrNotImplemented
 }
 
 func (exe *Ex) GetDepth(cp *CurrencyPair) (depth Depth, err error) {
-	return
+	return depth, ErrNotImplemented
 }
 
 func (exe *Ex) OrderState(s interface{}) string {
-	return s.(string)
+	if state, ok := s.(string); ok {
+		return state
+	}
+	return Unknown
 }
 
 func (exe *Ex) OrderSide(s string) string {
@@ -88,15 +97,19 @@ func (exe *Ex) OrderSide(s string) string {
 }
 
 func (exe *Ex) NewOrder(o *Order) (id string, err error) {
-	return
+	return "", ErrNotImplemented
 }
 
 func (exe *Ex) CancelOrder(o *Order) (err error) {
-	return
+	return ErrNotImplemented
 }
 
 func (exe *Ex) QueryOrder(o *Order) (order Order, err error) {
-	return
+	return order, ErrNotImplemented
+}
+
+func (exe *Ex) Capabilities() Capabilities {
+	return Capabilities{}
 }
 
 func NewEx() Exchange {
@@ -2,20 +2,38 @@ package lib
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	. "github.com/bitly/go-simplejson"
 )
 
+/*
+ * Reference page: https://www.exx.com/help/restApi
+ */
+
+// code of the responses which succeed
+const exxSuccess = "100"
+
 type Exx struct {
 	accesskeyid, secretkeyid string
 }
 
 func (exx *Exx) respErr(js *Json) (interface{}, error) {
-	return nil, nil
+	if reason, err := js.Get("error").String(); err == nil {
+		return nil, errors.New(reason)
+	}
+	if reason, err := js.Get("message").String(); err == nil {
+		if code, ok := js.CheckGet("code"); ok {
+			return nil, fmt.Errorf("%v: %s", code.Interface(), reason)
+		}
+		return nil, errors.New(reason)
+	}
+	return nil, errors.New(Unknown)
 }
 
 func (exx *Exx) ToSymbol(cp *CurrencyPair) string {
@@ -23,7 +41,7 @@ func (exx *Exx) ToSymbol(cp *CurrencyPair) string {
 }
 
 func (exx *Exx) NormSymbol(cp *string) string {
-	return *cp
+	return strings.ToLower(*cp)
 }
 
 func (exx *Exx) sendReq(method, path string,
@@ -37,22 +55,19 @@ func (exx *Exx) sendReq(method, path string,
 		Header: header,
 	}
 
-	req.URL, _ = url.Parse("https://api.exx.com" + path)
 	if sign {
-		sign_params := map[string][]string{
-			"ex_key": {exx.accesskeyid},
+		req.URL, _ = url.Parse("https://trade.exx.com" + path)
+		q := url.Values{
+			"accesskey": {exx.accesskeyid},
+			"nonce":     {strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10)},
 		}
-
 		for k, v := range params {
-			sign_params[k] = v
+			q[k] = v
 		}
-
-		q := req.URL.Query()
-		q = sign_params
-		data := q.Encode() + "&secret_key=" + exx.secretkeyid
-		q.Add("sign", strings.ToUpper(GetMD5Hash(data)))
+		q.Add("signature", ComputeHmac512(q.Encode(), exx.secretkeyid))
 		req.URL.RawQuery = q.Encode()
 	} else {
+		req.URL, _ = url.Parse("https://api.exx.com" + path)
 		q := req.URL.Query()
 		q = params
 		req.URL.RawQuery = q.Encode()
@@ -66,10 +81,73 @@ func (exx *Exx) SetKey(access, secret string) {
 }
 
 func (exx *Exx) GetBalance() (balances []Balance, err error) {
+	status, js, err := exx.sendReq("GET", "/api/getBalance", nil, true)
+	if err != nil {
+		return
+	}
+
+	respOk := func(js *Json) (interface{}, error) {
+		if failedCode(js, exxSuccess) {
+			return exx.respErr(js)
+		}
+
+		var balances []Balance
+		funds, err := js.Get("funds").Map()
+		if err != nil {
+			return nil, errors.New("No funds in response")
+		}
+		for currency, f := range funds {
+			ff, err := toMap(f)
+			if err != nil {
+				return nil, err
+			}
+			total, err := toFloat(ff["total"])
+			if err != nil {
+				return nil, err
+			}
+			if total == 0 {
+				continue
+			}
+			balances = append(balances,
+				Balance{Currency: strings.ToLower(currency),
+					Balance: strconv.FormatFloat(total, 'f', -1, 64)})
+		}
+		return balances, nil
+	}
+
+	b, err := ProcessResp(status, js, respOk, exx.respErr)
+	if err == nil {
+		balances = b.([]Balance)
+	}
 	return
 }
 
 func (exx *Exx) GetPrice(cp *CurrencyPair) (price Price, err error) {
+	params := map[string][]string{
+		"currency": {exx.ToSymbol(cp)},
+	}
+
+	status, js, err := exx.sendReq("GET", "/data/v1/ticker", params, false)
+	if err != nil {
+		return
+	}
+
+	respOk := func(js *Json) (interface{}, error) {
+		if failedCode(js, exxSuccess) {
+			return exx.respErr(js)
+		}
+
+		price, err := getFloat(js.Get("ticker"), "last")
+		if err != nil {
+			return nil, err
+		}
+		return Price{price}, nil
+	}
+
+	p, err := ProcessResp(status, js, respOk, exx.respErr)
+	if err == nil {
+		price = p.(Price)
+	}
 	return
 }
 
@@ -81,7 +159,10 @@ func (exx *Exx) GetSymbols() (symbols []string, err error) {
 
 	respOk := func(js *Json) (interface{}, error) {
 		var s []string
-		data, _ := js.Map()
+		data, err := js.Map()
+		if err != nil {
+			return nil, err
+		}
 		for symbol, _ := range data {
 			s = append(s, symbol)
 		}
@@ -106,26 +187,15 @@ func (exx *Exx) GetDepth(cp *CurrencyPair) (depth Depth, err error) {
 	}
 
 	respOk := func(js *Json) (interface{}, error) {
-		var depth Depth
-		reason, err := js.Get("error").String()
-		if err == nil {
-			return nil, errors.New(reason)
-		}
-
-		asks, _ := js.Get("asks").Array()
-		for _, a := range asks {
-			uu := a.([]interface{})
-			price, _ := strconv.ParseFloat(uu[0].(string), 64)
-			amount, _ := strconv.ParseFloat(uu[1].(string), 64)
-			depth.Asks = append(depth.Asks, Unit{price, amount})
-		}
-		bids, _ := js.Get("bids").Array()
-		for _, b := range bids {
-			uu := b.([]interface{})
-			price, _ := strconv.ParseFloat(uu[0].(string), 64)
-			amount, _ := strconv.ParseFloat(uu[1].(string), 64)
-			depth.Bids = append(depth.Bids, Unit{price, amount})
+		if failedCode(js, exxSuccess) {
+			return exx.respErr(js)
+		}
+
+		depth, err := getDepth(js, "asks", "bids")
+		if err != nil {
+			return nil, err
 		}
+		depth.Asks = reverseUnits(depth.Asks)
 		return depth, nil
 	}
 
@@ -137,7 +207,15 @@ func (exx *Exx) GetDepth(cp *CurrencyPair) (depth Depth, err error) {
 }
 
 func (exx *Exx) OrderState(s interface{}) string {
-	return s.(string)
+	switch s {
+	case 0, 3:
+		return Alive
+	case 1:
+		return Cancelled
+	case 2:
+		return Filled
+	}
+	return Unknown
 }
 
 func (exx *Exx) OrderSide(s string) string {
@@ -145,17 +223,118 @@ func (exx *Exx) OrderSide(s string) string {
 }
 
 func (exx *Exx) NewOrder(o *Order) (id string, err error) {
+	params := map[string][]string{
+		"currency": {exx.ToSymbol(&o.CP)},
+		"type":     {o.Side},
+		"price":    {strconv.FormatFloat(o.Price, 'f', -1, 64)},
+		"amount":   {strconv.FormatFloat(o.Amount, 'f', -1, 64)},
+	}
+
+	status, js, err := exx.sendReq("GET", "/api/order", params, true)
+	if err != nil {
+		return
+	}
+
+	respOk := func(js *Json) (interface{}, error) {
+		if failedCode(js, exxSuccess) {
+			return exx.respErr(js)
+		}
+
+		return getId(js, "id")
+	}
+
+	oid, err := ProcessResp(status, js, respOk, exx.respErr)
+	if err == nil {
+		id = oid.(string)
+	}
 	return
 }
 
 func (exx *Exx) CancelOrder(o *Order) (err error) {
+	params := map[string][]string{
+		"id":       {o.Id},
+		"currency": {exx.ToSymbol(&o.CP)},
+	}
+
+	status, js, err := exx.sendReq("GET", "/api/cancel", params, true)
+	if err != nil {
+		return
+	}
+
+	respOk := func(js *Json) (interface{}, error) {
+		if failedCode(js, exxSuccess) {
+			return exx.respErr(js)
+		}
+		return nil, nil
+	}
+
+	_, err = ProcessResp(status, js, respOk, exx.respErr)
 	return
 }
 
 func (exx *Exx) QueryOrder(o *Order) (order Order, err error) {
+	params := map[string][]string{
+		"id":       {o.Id},
+		"currency": {exx.ToSymbol(&o.CP)},
+	}
+
+	status, js, err := exx.sendReq("GET", "/api/getOrder", params, true)
+	if err != nil {
+		return
+	}
+
+	respOk := func(js *Json) (interface{}, error) {
+		if failedCode(js, exxSuccess) {
+			return exx.respErr(js)
+		}
+
+		var order Order
+		var err error
+		if order.Id, err = getId(js, "id"); err != nil {
+			return nil, errors.New("No order " + o.Id)
+		}
+		symbol, err := getString(js, "currency")
+		if err != nil {
+			return nil, err
+		}
+		order.CP = NewCurrencyPair2(exx.NormSymbol(&symbol))
+		side, err := getString(js, "type")
+		if err != nil {
+			return nil, err
+		}
+		order.Side = exx.OrderSide(side)
+		if order.Price, err = toFloat(js.Get("price").Interface()); err != nil {
+			return nil, err
+		}
+		if order.Amount, err = toFloat(js.Get("total_amount").Interface()); err != nil {
+			return nil, err
+		}
+		if order.Executed, err = toFloat(js.Get("trade_amount").Interface()); err != nil {
+			return nil, err
+		}
+		order.Remain = order.Amount - order.Executed
+		state, err := js.Get("status").Int()
+		if err != nil {
+			return nil, errors.New("No status in response")
+		}
+		order.State = exx.OrderState(state)
+		return order, nil
+	}
+
+	od, err := ProcessResp(status, js, respOk, exx.respErr)
+	if err == nil {
+		order = od.(Order)
+	}
 	return
 }
 
+func (exx *Exx) Capabilities() Capabilities {
+	return Capabilities{
+		Operations: BasicOperations,
+		OrderTypes: []string{LimitOrder},
+	}
+}
+
 func NewExx() Exchange {
 	return new(Exx)
 }
@@ -1,7 +1,8 @@
 package lib
 
 import (
-	"encoding/json"
+	"errors"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -10,12 +11,31 @@ import (
 	. "github.com/bitly/go-simplejson"
 )
 
+/*
+ * Reference page: https://www.gate.io/api2
+ */
+
 type Gate struct {
 	accesskeyid, secretkeyid string
 }
 
 func (gate *Gate) respErr(js *Json) (interface{}, error) {
-	return nil, nil
+	reason, err := js.Get("message").String()
+	if err != nil {
+		return nil, errors.New(Unknown)
+	}
+	return nil, errors.New(reason)
+}
+
+// failed reports errors Gate returns with status 200, result is "false"
+func (gate *Gate) failed(js *Json) bool {
+	if result, err := js.Get("result").String(); err == nil {
+		return result == "false"
+	}
+	if result, err := js.Get("result").Bool(); err == nil {
+		return !result
+	}
+	return false
 }
 
 func (gate *Gate) ToSymbol(cp *CurrencyPair) string {
@@ -23,7 +43,7 @@ func (gate *Gate) ToSymbol(cp *CurrencyPair) string {
 }
 
 func (gate *Gate) NormSymbol(cp *string) string {
-	return *cp
+	return strings.ToLower(*cp)
 }
 
 func (gate *Gate) sendReq(method, path string,
@@ -37,22 +57,15 @@ func (gate *Gate) sendReq(method, path string,
 		Header: header,
 	}
 
-	req.URL, _ = url.Parse("http://data.gate.io" + path)
 	if sign {
-		sign_params := map[string][]string{
-			"ex_key": {gate.accesskeyid},
-		}
-
-		for k, v := range params {
-			sign_params[k] = v
-		}
-
-		q := req.URL.Query()
-		q = sign_params
-		data := q.Encode() + "&secret_key=" + gate.secretkeyid
-		q.Add("sign", strings.ToUpper(GetMD5Hash(data)))
-		req.URL.RawQuery = q.Encode()
+		req.URL, _ = url.Parse("https://api.gateio.io" + path)
+		data := url.Values(params).Encode()
+		req.Header.Add("KEY", gate.accesskeyid)
+		req.Header.Add("SIGN", ComputeHmac512(data, gate.secretkeyid))
+		req.Body = ioutil.NopCloser(strings.NewReader(data))
+		req.ContentLength = int64(len(data))
 	} else {
+		req.URL, _ = url.Parse("https://data.gateio.io" + path)
 		q := req.URL.Query()
 		q = params
 		req.URL.RawQuery = q.Encode()
@@ -65,11 +78,76 @@ func (gate *Gate) SetKey(access, secret string) {
 	gate.secretkeyid = secret
 }
 
+// GetBalance returns available and locked of each currency
 func (gate *Gate) GetBalance() (balances []Balance, err error) {
+	status, js, err := gate.sendReq("POST", "/api2/1/private/balances", nil, true)
+	if err != nil {
+		return
+	}
+
+	respOk := func(js *Json) (interface{}, error) {
+		if gate.failed(js) {
+			return gate.respErr(js)
+		}
+
+		total := map[string]float64{}
+		for _, key := range []string{"available", "locked"} {
+			v := js.Get(key).Interface()
+			// [] if empty
+			if a, ok := v.([]interface{}); ok && len(a) == 0 {
+				continue
+			}
+			m, err := toMap(v)
+			if err != nil {
+				return nil, err
+			}
+			for c, b := range m {
+				f, err := toFloat(b)
+				if err != nil {
+					return nil, err
+				}
+				total[c] += f
+			}
+		}
+
+		var balances []Balance
+		for c, b := range total {
+			balances = append(balances,
+				Balance{Currency: strings.ToLower(c),
+					Balance: strconv.FormatFloat(b, 'f', -1, 64)})
+		}
+		return balances, nil
+	}
+
+	b, err := ProcessResp(status, js, respOk, gate.respErr)
+	if err == nil {
+		balances = b.([]Balance)
+	}
 	return
 }
 
 func (gate *Gate) GetPrice(cp *CurrencyPair) (price Price, err error) {
+	status, js, err := gate.sendReq("GET", "/api2/1/ticker/"+gate.ToSymbol(cp), nil, false)
+	if err != nil {
+		return
+	}
+
+	respOk := func(js *Json) (interface{}, error) {
+		if gate.failed(js) {
+			return gate.respErr(js)
+		}
+
+		p, err := getFloat(js, "last")
+		if err != nil {
+			return nil, err
+		}
+		return Price{p}, nil
+	}
+
+	p, err := ProcessResp(status, js, respOk, gate.respErr)
+	if err == nil {
+		price = p.(Price)
+	}
 	return
 }
 
@@ -81,10 +159,16 @@ func (gate *Gate) GetSymbols() (symbols []string, err error) {
 
 	respOk := func(js *Json) (interface{}, error) {
 		var s []string
-		data, _ := js.Array()
+		data, err := js.Array()
+		if err != nil {
+			return nil, err
+		}
 		for _, d := range data {
-			dd := d.(string)
-			s = append(s, dd)
+			dd, err := toString(d)
+			if err != nil {
+				return nil, err
+			}
+			s = append(s, gate.NormSymbol(&dd))
 		}
 		return s, nil
 	}
@@ -103,39 +187,15 @@ func (gate *Gate) GetDepth(cp *CurrencyPair) (depth Depth, err error) {
 	}
 
 	respOk := func(js *Json) (interface{}, error) {
-		var depth Depth
-		asks, _ := js.Get("asks").Array()
-		for _, a := range asks {
-			var price, amount float64
-			uu := a.([]interface{})
-			if _, ok := uu[0].(string); ok {
-				price, _ = strconv.ParseFloat(uu[0].(string), 64)
-			} else if _, ok := uu[0].(json.Number); ok {
-				price, _ = uu[0].(json.Number).Float64()
-			}
-			if _, ok := uu[1].(string); ok {
-				amount, _ = strconv.ParseFloat(uu[1].(string), 64)
-			} else if _, ok := uu[1].(json.Number); ok {
-				amount, _ = uu[1].(json.Number).Float64()
-			}
-			depth.Asks = append(depth.Asks, Unit{price, amount})
-		}
-		bids, _ := js.Get("bids").Array()
-		for _, b := range bids {
-			var price, amount float64
-			uu := b.([]interface{})
-			if _, ok := uu[0].(string); ok {
-				price, _ = strconv.ParseFloat(uu[0].(string), 64)
-			} else if _, ok := uu[0].(json.Number); ok {
-				price, _ = uu[0].(json.Number).Float64()
-			}
-			if _, ok := uu[1].(string); ok {
-				amount, _ = strconv.ParseFloat(uu[1].(string), 64)
-			} else if _, ok := uu[1].(json.Number); ok {
-				amount, _ = uu[1].(json.Number).Float64()
-			}
-			depth.Bids = append(depth.Bids, Unit{price, amount})
+		if gate.failed(js) {
+			return gate.respErr(js)
+		}
+
+		depth, err := getDepth(js, "asks", "bids")
+		if err != nil {
+			return nil, err
 		}
+		depth.Asks = reverseUnits(depth.Asks)
 		return depth, nil
 	}
 
@@ -147,7 +207,15 @@ func (gate *Gate) GetDepth(cp *CurrencyPair) (depth Depth, err error) {
 }
 
 func (gate *Gate) OrderState(s interface{}) string {
-	return s.(string)
+	switch s {
+	case "open":
+		return Alive
+	case "cancelled":
+		return Cancelled
+	case "closed":
+		return Filled
+	}
+	return Unknown
 }
 
 func (gate *Gate) OrderSide(s string) string {
@@ -155,17 +223,121 @@ func (gate *Gate) OrderSide(s string) string {
 }
 
 func (gate *Gate) NewOrder(o *Order) (id string, err error) {
+	params := map[string][]string{
+		"currencyPair": {gate.ToSymbol(&o.CP)},
+		"rate":         {strconv.FormatFloat(o.Price, 'f', -1, 64)},
+		"amount":       {strconv.FormatFloat(o.Amount, 'f', -1, 64)},
+	}
+
+	status, js, err := gate.sendReq("POST", "/api2/1/private/"+o.Side, params, true)
+	if err != nil {
+		return
+	}
+
+	respOk := func(js *Json) (interface{}, error) {
+		if gate.failed(js) {
+			return gate.respErr(js)
+		}
+
+		return getId(js, "orderNumber")
+	}
+
+	oid, err := ProcessResp(status, js, respOk, gate.respErr)
+	if err == nil {
+		id = oid.(string)
+	}
 	return
 }
 
 func (gate *Gate) CancelOrder(o *Order) (err error) {
+	params := map[string][]string{
+		"orderNumber":  {o.Id},
+		"currencyPair": {gate.ToSymbol(&o.CP)},
+	}
+
+	status, js, err := gate.sendReq("POST", "/api2/1/private/cancelOrder", params, true)
+	if err != nil {
+		return
+	}
+
+	respOk := func(js *Json) (interface{}, error) {
+		if gate.failed(js) {
+			return gate.respErr(js)
+		}
+		return nil, nil
+	}
+
+	_, err = ProcessResp(status, js, respOk, gate.respErr)
 	return
 }
 
 func (gate *Gate) QueryOrder(o *Order) (order Order, err error) {
+	params := map[string][]string{
+		"orderNumber":  {o.Id},
+		"currencyPair": {gate.ToSymbol(&o.CP)},
+	}
+
+	status, js, err := gate.sendReq("POST", "/api2/1/private/getOrder", params, true)
+	if err != nil {
+		return
+	}
+
+	respOk := func(js *Json) (interface{}, error) {
+		if gate.failed(js) {
+			return gate.respErr(js)
+		}
+
+		od, ok := js.CheckGet("order")
+		if !ok {
+			return nil, errors.New("No order " + o.Id)
+		}
+		var order Order
+		var err error
+		if order.Id, err = getId(od, "orderNumber"); err != nil {
+			return nil, err
+		}
+		symbol, err := getString(od, "currencyPair")
+		if err != nil {
+			return nil, err
+		}
+		order.CP = NewCurrencyPair2(gate.NormSymbol(&symbol))
+		side, err := getString(od, "type")
+		if err != nil {
+			return nil, err
+		}
+		order.Side = gate.OrderSide(side)
+		if order.Price, err = toFloat(od.Get("initialRate").Interface()); err != nil {
+			return nil, err
+		}
+		if order.Amount, err = toFloat(od.Get("initialAmount").Interface()); err != nil {
+			return nil, err
+		}
+		if order.Executed, err = toFloat(od.Get("filledAmount").Interface()); err != nil {
+			return nil, err
+		}
+		order.Remain = order.Amount - order.Executed
+		state, err := getString(od, "status")
+		if err != nil {
+			return nil, err
+		}
+		order.State = gate.OrderState(state)
+		return order, nil
+	}
+
+	od, err := ProcessResp(status, js, respOk, gate.respErr)
+	if err == nil {
+		order = od.(Order)
+	}
 	return
 }
 
+func (gate *Gate) Capabilities() Capabilities {
+	return Capabilities{
+		Operations: BasicOperations,
+		OrderTypes: []string{LimitOrder},
+	}
+}
+
 func NewGate() Exchange {
 	return new(Gate)
 }
@@ -0,0 +1,202 @@
+package lib
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+ * Historical market data for the backtest engine. Data comes from csv
+ * files or from recordings, which are json lines of Event written by
+ * Recorder.
+ *
+ * csv layouts (a header row is allowed, time is unix seconds,
+ * milliseconds or RFC3339):
+ *   klines: time,open,high,low,close,volume
+ *   trades: time,price,amount[,side]
+ *   depth:  time,side,price,amount (rows of one time form a snapshot)
+ */
+
+type Event struct {
+	Time  time.Time
+	Kline *Kline `json:",omitempty"`
+	Trade *Trade `json:",omitempty"`
+	Depth *Depth `json:",omitempty"`
+}
+
+func parseTime(s string) (time.Time, error) {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if n > 1e12 {
+			return time.Unix(0, n*int64(time.Millisecond)), nil
+		}
+		return time.Unix(n, 0), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func parseFloats(fields []string) ([]float64, error) {
+	fs := make([]float64, len(fields))
+	for i, f := range fields {
+		v, err := strconv.ParseFloat(strings.TrimSpace(f), 64)
+		if err != nil {
+			return nil, err
+		}
+		fs[i] = v
+	}
+	return fs, nil
+}
+
+// readCSV calls row for each record with its time, skipping a header.
+func readCSV(r io.Reader, columns int, row func(t time.Time, rec []string) error) error {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	line := 0
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		line++
+		t, err := parseTime(strings.TrimSpace(rec[0]))
+		if err != nil {
+			if line == 1 {
+				continue
+			}
+			return errors.New("line " + strconv.Itoa(line) + ": bad time " + rec[0])
+		}
+		if len(rec) < columns {
+			return errors.New("line " + strconv.Itoa(line) + ": too few columns")
+		}
+		if err := row(t, rec); err != nil {
+			return errors.New("line " + strconv.Itoa(line) + ": " + err.Error())
+		}
+	}
+}
+
+func ReadKlinesCSV(r io.Reader) (events []Event, err error) {
+	err = readCSV(r, 6, func(t time.Time, rec []string) error {
+		fs, err := parseFloats(rec[1:6])
+		if err != nil {
+			return err
+		}
+		events = append(events, Event{Time: t,
+			Kline: &Kline{t, fs[0], fs[1], fs[2], fs[3], fs[4]}})
+		return nil
+	})
+	return
+}
+
+func ReadTradesCSV(r io.Reader) (events []Event, err error) {
+	err = readCSV(r, 3, func(t time.Time, rec []string) error {
+		fs, err := parseFloats(rec[1:3])
+		if err != nil {
+			return err
+		}
+		trade := &Trade{Time: t, Price: fs[0], Amount: fs[1]}
+		if len(rec) > 3 {
+			trade.Side = strings.ToLower(strings.TrimSpace(rec[3]))
+		}
+		events = append(events, Event{Time: t, Trade: trade})
+		return nil
+	})
+	return
+}
+
+func ReadDepthCSV(r io.Reader) (events []Event, err error) {
+	err = readCSV(r, 4, func(t time.Time, rec []string) error {
+		fs, err := parseFloats(rec[2:4])
+		if err != nil {
+			return err
+		}
+		if len(events) == 0 || !events[len(events)-1].Time.Equal(t) {
+			events = append(events, Event{Time: t, Depth: &Depth{}})
+		}
+		depth := events[len(events)-1].Depth
+		switch strings.ToLower(strings.TrimSpace(rec[1])) {
+		case "ask", "asks", "sell":
+			depth.Asks = append(depth.Asks, Unit{fs[0], fs[1]})
+		case "bid", "bids", "buy":
+			depth.Bids = append(depth.Bids, Unit{fs[0], fs[1]})
+		default:
+			return errors.New("bad side " + rec[1])
+		}
+		return nil
+	})
+	for _, ev := range events {
+		ev.Depth.Asks = sortUnits(ev.Depth.Asks, true)
+		ev.Depth.Bids = sortUnits(ev.Depth.Bids, false)
+	}
+	return
+}
+
+func ReadRecording(r io.Reader) (events []Event, err error) {
+	dec := json.NewDecoder(r)
+	for {
+		var ev Event
+		if err = dec.Decode(&ev); err == io.EOF {
+			return events, nil
+		} else if err != nil {
+			return
+		}
+		events = append(events, ev)
+	}
+}
+
+func WriteEvent(w io.Writer, ev Event) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// MergeEvents merges several streams into one ordered by time.
+func MergeEvents(streams ...[]Event) (events []Event) {
+	for _, s := range streams {
+		events = append(events, s...)
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Time.Before(events[j].Time)
+	})
+	return
+}
+
+// Recorder wraps an Exchange and records the prices and depth it fetches,
+// so a live session could be replayed by a Backtest later.
+type Recorder struct {
+	Exchange
+	w io.Writer
+}
+
+func NewRecorder(ex Exchange, w io.Writer) *Recorder {
+	return &Recorder{Exchange: ex, w: w}
+}
+
+func (r *Recorder) GetPrice(cp *CurrencyPair) (price Price, err error) {
+	price, err = r.Exchange.GetPrice(cp)
+	if err == nil {
+		now := time.Now()
+		err = WriteEvent(r.w, Event{Time: now,
+			Trade: &Trade{Time: now, Price: price.Price}})
+	}
+	return
+}
+
+func (r *Recorder) GetDepth(cp *CurrencyPair) (depth Depth, err error) {
+	depth, err = r.Exchange.GetDepth(cp)
+	if err == nil {
+		err = WriteEvent(r.w, Event{Time: time.Now(), Depth: &depth})
+	}
+	return
+}
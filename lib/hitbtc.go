@@ -1,6 +1,8 @@
 package lib
 
 import (
+	"errors"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -9,12 +11,26 @@ import (
 	. "github.com/bitly/go-simplejson"
 )
 
+/*
+ * Reference page: https://api.hitbtc.com/api/2/explore/
+ *
+ * Orders are placed with a client order id, which is the id NewOrder
+ * returns and CancelOrder and QueryOrder take.
+ */
+
 type HitBTC struct {
 	accesskeyid, secretkeyid string
 }
 
+// hitbtcQuotes are tried in order to split a symbol
+var hitbtcQuotes = []string{"USDT", "TUSD", "DAI", "BTC", "ETH", "EOS", "USD"}
+
 func (hb *HitBTC) respErr(js *Json) (interface{}, error) {
-	return nil, nil
+	reason, err := js.Get("error").Get("message").String()
+	if err != nil {
+		return nil, errors.New(Unknown)
+	}
+	return nil, errors.New(reason)
 }
 
 func (hb *HitBTC) ToSymbol(cp *CurrencyPair) string {
@@ -22,7 +38,16 @@ func (hb *HitBTC) ToSymbol(cp *CurrencyPair) string {
 }
 
 func (hb *HitBTC) NormSymbol(cp *string) string {
-	return *cp
+	s := strings.ToUpper(*cp)
+	for _, q := range hitbtcQuotes {
+		if strings.HasSuffix(s, q) && len(s) > len(q) {
+			return strings.ToLower(s[:len(s)-len(q)] + "_" + q)
+		}
+	}
+	if len(s) < 6 {
+		return strings.ToLower(s)
+	}
+	return strings.ToLower(s[:3] + "_" + s[3:])
 }
 
 func (hb *HitBTC) sendReq(method, path string,
@@ -38,19 +63,12 @@ func (hb *HitBTC) sendReq(method, path string,
 
 	req.URL, _ = url.Parse("https://api.hitbtc.com" + path)
 	if sign {
-		sign_params := map[string][]string{
-			"ex_key": {hb.accesskeyid},
-		}
-
-		for k, v := range params {
-			sign_params[k] = v
-		}
-
-		q := req.URL.Query()
-		q = sign_params
-		data := q.Encode() + "&secret_key=" + hb.secretkeyid
-		q.Add("sign", strings.ToUpper(GetMD5Hash(data)))
-		req.URL.RawQuery = q.Encode()
+		req.SetBasicAuth(hb.accesskeyid, hb.secretkeyid)
+	}
+	if method == "POST" {
+		data := url.Values(params).Encode()
+		req.Body = ioutil.NopCloser(strings.NewReader(data))
+		req.ContentLength = int64(len(data))
 	} else {
 		q := req.URL.Query()
 		q = params
@@ -64,11 +82,72 @@ func (hb *HitBTC) SetKey(access, secret string) {
 	hb.secretkeyid = secret
 }
 
+// GetBalance returns the trading balance, available and reserved, of the
+// currencies held
 func (hb *HitBTC) GetBalance() (balances []Balance, err error) {
+	status, js, err := hb.sendReq("GET", "/api/2/trading/balance", nil, true)
+	if err != nil {
+		return
+	}
+
+	respOk := func(js *Json) (interface{}, error) {
+		var balances []Balance
+		bs, err := js.Array()
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range bs {
+			bt, err := toMap(b)
+			if err != nil {
+				return nil, err
+			}
+			currency, err := toString(bt["currency"])
+			if err != nil {
+				return nil, err
+			}
+			available, err := toFloat(bt["available"])
+			if err != nil {
+				return nil, err
+			}
+			reserved, err := toFloat(bt["reserved"])
+			if err != nil {
+				return nil, err
+			}
+			if available+reserved == 0 {
+				continue
+			}
+			balances = append(balances,
+				Balance{Currency: strings.ToLower(currency),
+					Balance: strconv.FormatFloat(available+reserved, 'f', -1, 64)})
+		}
+		return balances, nil
+	}
+
+	b, err := ProcessResp(status, js, respOk, hb.respErr)
+	if err == nil {
+		balances = b.([]Balance)
+	}
 	return
 }
 
 func (hb *HitBTC) GetPrice(cp *CurrencyPair) (price Price, err error) {
+	status, js, err := hb.sendReq("GET", "/api/2/public/ticker/"+hb.ToSymbol(cp), nil, false)
+	if err != nil {
+		return
+	}
+
+	respOk := func(js *Json) (interface{}, error) {
+		price, err := getFloat(js, "last")
+		if err != nil {
+			return nil, err
+		}
+		return Price{price}, nil
+	}
+
+	p, err := ProcessResp(status, js, respOk, hb.respErr)
+	if err == nil {
+		price = p.(Price)
+	}
 	return
 }
 
@@ -80,13 +159,20 @@ func (hb *HitBTC) GetSymbols() (symbols []string, err error) {
 
 	respOk := func(js *Json) (interface{}, error) {
 		var s []string
-		data, _ := js.Array()
+		data, err := js.Array()
+		if err != nil {
+			return nil, err
+		}
 		for _, d := range data {
-			dd := d.(map[string]interface{})
-			symbol := strings.ToLower(dd["id"].(string))
-			base := symbol[0:3]
-			quote := symbol[3:]
-			s = append(s, base+"_"+quote)
+			dd, err := toMap(d)
+			if err != nil {
+				return nil, err
+			}
+			symbol, err := toString(dd["id"])
+			if err != nil {
+				return nil, err
+			}
+			s = append(s, hb.NormSymbol(&symbol))
 		}
 		return s, nil
 	}
@@ -106,19 +192,12 @@ func (hb *HitBTC) GetDepth(cp *CurrencyPair) (depth Depth, err error) {
 
 	respOk := func(js *Json) (interface{}, error) {
 		var depth Depth
-		asks, _ := js.Get("ask").Array()
-		for _, a := range asks {
-			uu := a.(map[string]interface{})
-			price, _ := strconv.ParseFloat(uu["price"].(string), 64)
-			amount, _ := strconv.ParseFloat(uu["size"].(string), 64)
-			depth.Asks = append([]Unit{Unit{price, amount}}, depth.Asks...)
+		var err error
+		if depth.Asks, err = toUnitsOf(js.Get("ask").Interface(), "price", "size"); err != nil {
+			return nil, err
 		}
-		bids, _ := js.Get("bid").Array()
-		for _, b := range bids {
-			uu := b.(map[string]interface{})
-			price, _ := strconv.ParseFloat(uu["price"].(string), 64)
-			amount, _ := strconv.ParseFloat(uu["size"].(string), 64)
-			depth.Bids = append(depth.Bids, Unit{price, amount})
+		if depth.Bids, err = toUnitsOf(js.Get("bid").Interface(), "price", "size"); err != nil {
+			return nil, err
 		}
 		return depth, nil
 	}
@@ -131,25 +210,142 @@ func (hb *HitBTC) GetDepth(cp *CurrencyPair) (depth Depth, err error) {
 }
 
 func (hb *HitBTC) OrderState(s interface{}) string {
-	return s.(string)
+	switch s {
+	case "new", "suspended", "partiallyFilled":
+		return Alive
+	case "canceled", "expired":
+		return Cancelled
+	case "filled":
+		return Filled
+	}
+	return Unknown
 }
 
 func (hb *HitBTC) OrderSide(s string) string {
 	return s
 }
 
+func (hb *HitBTC) parseOrder(js *Json) (interface{}, error) {
+	var order Order
+	var err error
+	if order.Id, err = getString(js, "clientOrderId"); err != nil {
+		return nil, err
+	}
+	symbol, err := getString(js, "symbol")
+	if err != nil {
+		return nil, err
+	}
+	order.CP = NewCurrencyPair2(hb.NormSymbol(&symbol))
+	side, err := getString(js, "side")
+	if err != nil {
+		return nil, err
+	}
+	order.Side = hb.OrderSide(side)
+	if order.Price, err = getFloat(js, "price"); err != nil {
+		return nil, err
+	}
+	if order.Amount, err = getFloat(js, "quantity"); err != nil {
+		return nil, err
+	}
+	if order.Executed, err = getFloat(js, "cumQuantity"); err != nil {
+		return nil, err
+	}
+	order.Remain = order.Amount - order.Executed
+	state, err := getString(js, "status")
+	if err != nil {
+		return nil, err
+	}
+	order.State = hb.OrderState(state)
+	return order, nil
+}
+
 func (hb *HitBTC) NewOrder(o *Order) (id string, err error) {
+	params := map[string][]string{
+		"clientOrderId": {strings.Replace(GetUUID(), "-", "", -1)},
+		"symbol":        {hb.ToSymbol(&o.CP)},
+		"side":          {o.Side},
+		"type":          {"limit"},
+		"quantity":      {strconv.FormatFloat(o.Amount, 'f', -1, 64)},
+		"price":         {strconv.FormatFloat(o.Price, 'f', -1, 64)},
+	}
+
+	status, js, err := hb.sendReq("POST", "/api/2/order", params, true)
+	if err != nil {
+		return
+	}
+
+	respOk := func(js *Json) (interface{}, error) {
+		return getString(js, "clientOrderId")
+	}
+
+	oid, err := ProcessResp(status, js, respOk, hb.respErr)
+	if err == nil {
+		id = oid.(string)
+	}
 	return
 }
 
 func (hb *HitBTC) CancelOrder(o *Order) (err error) {
+	status, js, err := hb.sendReq("DELETE", "/api/2/order/"+o.Id, nil, true)
+	if err != nil {
+		return
+	}
+
+	respOk := func(js *Json) (interface{}, error) {
+		return nil, nil
+	}
+
+	_, err = ProcessResp(status, js, respOk, hb.respErr)
 	return
 }
 
+// QueryOrder looks for an active order first, then in the history
 func (hb *HitBTC) QueryOrder(o *Order) (order Order, err error) {
+	status, js, err := hb.sendReq("GET", "/api/2/order/"+o.Id, nil, true)
+	if err != nil {
+		return
+	}
+
+	od, err := ProcessResp(status, js, hb.parseOrder, hb.respErr)
+	if err == nil {
+		order = od.(Order)
+		return
+	}
+	// 20002 is order not found
+	if code, _ := js.Get("error").Get("code").Int(); code != 20002 {
+		return
+	}
+
+	params := map[string][]string{
+		"clientOrderId": {o.Id},
+	}
+	status, js, err = hb.sendReq("GET", "/api/2/history/order", params, true)
+	if err != nil {
+		return
+	}
+
+	respOk := func(js *Json) (interface{}, error) {
+		if orders, _ := js.Array(); len(orders) == 0 {
+			return nil, errors.New("No order " + o.Id)
+		}
+		return hb.parseOrder(js.GetIndex(0))
+	}
+
+	od, err = ProcessResp(status, js, respOk, hb.respErr)
+	if err == nil {
+		order = od.(Order)
+	}
 	return
 }
 
+func (hb *HitBTC) Capabilities() Capabilities {
+	return Capabilities{
+		Operations: BasicOperations,
+		OrderTypes: []string{LimitOrder},
+		Features:   []string{ClientOrderId},
+	}
+}
+
 func NewHitBTC() Exchange {
 	return new(HitBTC)
 }
@@ -92,11 +92,12 @@ func (hb *Huobi) GetAccount() (account string, err error) {
 	respOk := func(js *Json) (interface{}, error) {
 		status, _ := js.Get("status").String()
 		if status == "ok" {
-			acc, _ := js.Get("data").Array()
-			for _, a := range acc {
-				at := a.(map[string]interface{})
-				account, _ := at["id"].(json.Number).Int64()
-				return strconv.FormatInt(account, 10), nil
+			acc, err := toArray(js.Get("data").Interface())
+			if err != nil {
+				return nil, err
+			}
+			if len(acc) > 0 {
+				return getId(js.Get("data").GetIndex(0), "id")
 			}
 		} else {
 			reason, _ := js.Get("err-msg").String()
@@ -130,14 +131,29 @@ func (hb *Huobi) GetBalance() (balances []Balance, err error) {
 	respOk := func(js *Json) (interface{}, error) {
 		status, _ := js.Get("status").String()
 		if status == "ok" {
-			list, _ := js.Get("data").Get("list").Array()
+			list, err := toArray(js.Get("data").Get("list").Interface())
+			if err != nil {
+				return nil, err
+			}
 
 			for _, l := range list {
-				b := l.(map[string]interface{})
-				if b["balance"].(string) != "0.000000000000000000" {
+				b, err := toMap(l)
+				if err != nil {
+					return nil, err
+				}
+				currency, err := toString(b["currency"])
+				if err != nil {
+					return nil, err
+				}
+				balance, err := toString(b["balance"])
+				if err != nil {
+					return nil, err
+				}
+				if f, err := toFloat(balance); err != nil {
+					return nil, err
+				} else if f != 0 {
 					balances = append(balances,
-						Balance{Currency: b["currency"].(string),
-							Balance: b["balance"].(string)})
+						Balance{Currency: currency, Balance: balance})
 				}
 			}
 			return balances, nil
@@ -175,13 +191,18 @@ func (hb *Huobi) GetPrice(cp *CurrencyPair) (price Price, err error) {
 	respOk := func(js *Json) (interface{}, error) {
 		status, _ := js.Get("status").String()
 		if status == "ok" {
-			data, _ := js.Get("tick").Get("data").Array()
-			for _, d := range data {
-				dd := d.(map[string]interface{})
-				ddd, _ := dd["price"].(json.Number).Float64()
-				return Price{ddd}, nil
+			data, err := toArray(js.Get("tick").Get("data").Interface())
+			if err != nil {
+				return nil, err
 			}
-			return nil, nil
+			if len(data) == 0 {
+				return nil, errors.New("No trade")
+			}
+			price, err := getFloat(js.Get("tick").Get("data").GetIndex(0), "price")
+			if err != nil {
+				return nil, err
+			}
+			return Price{price}, nil
 		} else {
 			reason, _ := js.Get("err-msg").String()
 			err = errors.New(reason)
@@ -207,11 +228,23 @@ func (hb *Huobi) GetSymbols() (symbols []string, err error) {
 		status, _ := js.Get("status").String()
 		if status == "ok" {
 			var s []string
-			data, _ := js.Get("data").Array()
+			data, err := toArray(js.Get("data").Interface())
+			if err != nil {
+				return nil, err
+			}
 			for _, d := range data {
-				dd := d.(map[string]interface{})
-				base := dd["base-currency"].(string)
-				quote := dd["quote-currency"].(string)
+				dd, err := toMap(d)
+				if err != nil {
+					return nil, err
+				}
+				base, err := toString(dd["base-currency"])
+				if err != nil {
+					return nil, err
+				}
+				quote, err := toString(dd["quote-currency"])
+				if err != nil {
+					return nil, err
+				}
 				s = append(s, base+"_"+quote)
 			}
 			return s, nil
@@ -244,20 +277,9 @@ func (hb *Huobi) GetDepth(cp *CurrencyPair) (depth Depth, err error) {
 	respOk := func(js *Json) (interface{}, error) {
 		status, _ := js.Get("status").String()
 		if status == "ok" {
-			var depth Depth
-			asks, _ := js.Get("tick").Get("asks").Array()
-			for _, a := range asks {
-				uu := a.([]interface{})
-				price, _ := uu[0].(json.Number).Float64()
-				amount, _ := uu[1].(json.Number).Float64()
-				depth.Asks = append([]Unit{Unit{price, amount}}, depth.Asks...)
-			}
-			bids, _ := js.Get("tick").Get("bids").Array()
-			for _, b := range bids {
-				uu := b.([]interface{})
-				price, _ := uu[0].(json.Number).Float64()
-				amount, _ := uu[1].(json.Number).Float64()
-				depth.Bids = append(depth.Bids, Unit{price, amount})
+			depth, err := getDepth(js.Get("tick"), "asks", "bids")
+			if err != nil {
+				return nil, err
 			}
 			return depth, nil
 		} else {
@@ -276,12 +298,15 @@ func (hb *Huobi) GetDepth(cp *CurrencyPair) (depth Depth, err error) {
 }
 
 func (hb *Huobi) OrderState(s interface{}) string {
-	if s.(string) == "submitted" {
+	switch s {
+	case "pre-submitted", "submitting", "submitted", "partial-filled":
 		return Alive
-	} else if s.(string) == "canceled" {
+	case "canceled", "partial-canceled":
 		return Cancelled
+	case "filled":
+		return Filled
 	}
-	return s.(string)
+	return Unknown
 }
 
 func (hb *Huobi) OrderSide(s string) string {
@@ -315,8 +340,7 @@ func (hb *Huobi) NewOrder(o *Order) (id string, err error) {
 	respOk := func(js *Json) (interface{}, error) {
 		status, _ := js.Get("status").String()
 		if status == "ok" {
-			id, _ = js.Get("data").String()
-			return id, nil
+			return getId(js, "data")
 		} else {
 			reason, _ := js.Get("err-msg").String()
 			err = errors.New(reason)
@@ -364,20 +388,35 @@ func (hb *Huobi) QueryOrder(o *Order) (order Order, err error) {
 		status, _ := js.Get("status").String()
 		if status == "ok" {
 			var order Order
-			id, _ := js.Get("data").Get("id").Int64()
-			order.Id = strconv.FormatInt(id, 10)
-			symbol, _ := js.Get("data").Get("symbol").String()
+			var err error
+			data := js.Get("data")
+			if order.Id, err = getId(data, "id"); err != nil {
+				return nil, err
+			}
+			symbol, err := getString(data, "symbol")
+			if err != nil {
+				return nil, err
+			}
 			order.CP = NewCurrencyPair2(hb.NormSymbol(&symbol))
-			side, _ := js.Get("data").Get("type").String()
+			side, err := getString(data, "type")
+			if err != nil {
+				return nil, err
+			}
 			order.Side = hb.OrderSide(side)
-			price, _ := js.Get("data").Get("price").String()
-			order.Price, _ = strconv.ParseFloat(price, 64)
-			amount, _ := js.Get("data").Get("amount").String()
-			order.Amount, _ = strconv.ParseFloat(amount, 64)
-			status, _ := js.Get("data").Get("state").String()
+			if order.Price, err = getFloat(data, "price"); err != nil {
+				return nil, err
+			}
+			if order.Amount, err = getFloat(data, "amount"); err != nil {
+				return nil, err
+			}
+			status, err := getString(data, "state")
+			if err != nil {
+				return nil, err
+			}
 			order.State = hb.OrderState(status)
-			executed, _ := js.Get("data").Get("field-amount").String()
-			order.Executed, _ = strconv.ParseFloat(executed, 64)
+			if order.Executed, err = getFloat(data, "field-amount"); err != nil {
+				return nil, err
+			}
 			order.Remain = order.Amount - order.Executed
 			return order, nil
 		} else {
@@ -395,6 +434,13 @@ func (hb *Huobi) QueryOrder(o *Order) (order Order, err error) {
 	return
 }
 
+func (hb *Huobi) Capabilities() Capabilities {
+	return Capabilities{
+		Operations: BasicOperations,
+		OrderTypes: []string{LimitOrder},
+	}
+}
+
 func NewHuobi() Exchange {
 	return new(Huobi)
 }
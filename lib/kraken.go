@@ -1,27 +1,113 @@
 package lib
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	. "github.com/bitly/go-simplejson"
 )
 
+/*
+ * Reference page: https://docs.kraken.com/rest/
+ *
+ * Kraken names the assets it listed first with a prefix, X for crypto and
+ * Z for fiat, so eth_btc is XETHXXBT and btc_usd is XXBTZUSD, while later
+ * ones are plain, like DOTUSD. Bitcoin is XBT and dogecoin XDG.
+ */
+
 type Kraken struct {
 	accesskeyid, secretkeyid string
 }
 
+var krakenPrefix = map[string]string{
+	"xbt": "X", "eth": "X", "ltc": "X", "xrp": "X", "xlm": "X", "etc": "X",
+	"zec": "X", "xmr": "X", "rep": "X", "mln": "X", "xdg": "X",
+	"usd": "Z", "eur": "Z", "cad": "Z", "jpy": "Z", "gbp": "Z",
+}
+
+var krakenAlias = map[string]string{
+	"xbt": "btc",
+	"xdg": "doge",
+}
+
+// krakenQuotes are tried in order to split a pair name without separator
+var krakenQuotes = []string{"ZUSD", "ZEUR", "ZCAD", "ZJPY", "ZGBP", "XXBT",
+	"XETH", "USDT", "USDC", "USD", "EUR", "CAD", "JPY", "GBP", "CHF", "AUD",
+	"XBT", "ETH", "DAI", "DOT"}
+
+// krakenCurrency converts asset of Kraken to a currency, XXBT to btc
+func krakenCurrency(asset string) string {
+	a := strings.ToLower(asset)
+	if len(a) == 4 {
+		if p, ok := krakenPrefix[a[1:]]; ok && strings.ToLower(p) == a[:1] {
+			a = a[1:]
+		}
+	}
+	if c, ok := krakenAlias[a]; ok {
+		a = c
+	}
+	return a
+}
+
+// krakenAsset converts currency to an asset of Kraken, btc to XBT
+func krakenAsset(currency string) string {
+	c := strings.ToLower(currency)
+	for a, cc := range krakenAlias {
+		if cc == c {
+			c = a
+		}
+	}
+	return c
+}
+
 func (kk *Kraken) respErr(js *Json) (interface{}, error) {
-	return nil, nil
+	reasons, _ := js.Get("error").StringArray()
+	if len(reasons) == 0 {
+		return nil, errors.New(Unknown)
+	}
+	return nil, errors.New(strings.Join(reasons, ", "))
+}
+
+// result returns the only entry of the result, which is keyed by pair name
+func (kk *Kraken) result(js *Json) (*Json, error) {
+	data, _ := js.Get("result").Map()
+	for k, _ := range data {
+		return js.Get("result").Get(k), nil
+	}
+	return nil, errors.New("Empty result")
 }
 
 func (kk *Kraken) ToSymbol(cp *CurrencyPair) string {
-	return cp.ToSymbol("_")
+	base := krakenAsset(cp.CurrencyA.Symbol)
+	quote := krakenAsset(cp.CurrencyB.Symbol)
+	pb, okb := krakenPrefix[base]
+	pq, okq := krakenPrefix[quote]
+	if okb && okq {
+		return pb + strings.ToUpper(base) + pq + strings.ToUpper(quote)
+	}
+	return strings.ToUpper(base + quote)
 }
 
 func (kk *Kraken) NormSymbol(cp *string) string {
-	return *cp
+	s := strings.ToUpper(*cp)
+	for _, q := range krakenQuotes {
+		if strings.HasSuffix(s, q) && len(s) > len(q) {
+			return krakenCurrency(s[:len(s)-len(q)]) + "_" + krakenCurrency(q)
+		}
+	}
+	if len(s) < 6 {
+		return strings.ToLower(s)
+	}
+	return krakenCurrency(s[:len(s)-3]) + "_" + krakenCurrency(s[len(s)-3:])
 }
 
 func (kk *Kraken) sendReq(method, path string,
@@ -37,19 +123,27 @@ func (kk *Kraken) sendReq(method, path string,
 
 	req.URL, _ = url.Parse("https://api.kraken.com" + path)
 	if sign {
-		sign_params := map[string][]string{
-			"ex_key": {kk.accesskeyid},
+		secret, err := base64.StdEncoding.DecodeString(kk.secretkeyid)
+		if err != nil {
+			return 0, nil, errors.New("Secret key is not base64")
 		}
 
+		nonce := strconv.FormatInt(time.Now().UnixNano(), 10)
+		q := url.Values{"nonce": {nonce}}
 		for k, v := range params {
-			sign_params[k] = v
+			q[k] = v
 		}
+		data := q.Encode()
 
-		q := req.URL.Query()
-		q = sign_params
-		data := q.Encode() + "&secret_key=" + kk.secretkeyid
-		q.Add("sign", strings.ToUpper(GetMD5Hash(data)))
-		req.URL.RawQuery = q.Encode()
+		// API-Sign = HMAC-SHA512(path + SHA256(nonce + data)) of decoded secret
+		sha := sha256.Sum256([]byte(nonce + data))
+		mac := hmac.New(sha512.New, secret)
+		mac.Write(append([]byte(path), sha[:]...))
+
+		req.Header.Add("API-Key", kk.accesskeyid)
+		req.Header.Add("API-Sign", base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+		req.Body = ioutil.NopCloser(strings.NewReader(data))
+		req.ContentLength = int64(len(data))
 	} else {
 		q := req.URL.Query()
 		q = params
@@ -64,10 +158,71 @@ func (kk *Kraken) SetKey(access, secret string) {
 }
 
 func (kk *Kraken) GetBalance() (balances []Balance, err error) {
+	status, js, err := kk.sendReq("POST", "/0/private/Balance", nil, true)
+	if err != nil {
+		return
+	}
+
+	respOk := func(js *Json) (interface{}, error) {
+		if reasons, _ := js.Get("error").Array(); len(reasons) > 0 {
+			return kk.respErr(js)
+		}
+
+		var balances []Balance
+		data, err := js.Get("result").Map()
+		if err != nil {
+			return nil, errors.New("No result in response")
+		}
+		for asset, b := range data {
+			balance, err := toString(b)
+			if err != nil {
+				return nil, err
+			}
+			if _, err = toFloat(balance); err != nil {
+				return nil, err
+			}
+			balances = append(balances,
+				Balance{Currency: krakenCurrency(asset), Balance: balance})
+		}
+		return balances, nil
+	}
+
+	b, err := ProcessResp(status, js, respOk, kk.respErr)
+	if err == nil {
+		balances = b.([]Balance)
+	}
 	return
 }
 
 func (kk *Kraken) GetPrice(cp *CurrencyPair) (price Price, err error) {
+	params := map[string][]string{
+		"pair": {kk.ToSymbol(cp)},
+	}
+	status, js, err := kk.sendReq("GET", "/0/public/Ticker", params, false)
+	if err != nil {
+		return
+	}
+
+	respOk := func(js *Json) (interface{}, error) {
+		if reasons, _ := js.Get("error").Array(); len(reasons) > 0 {
+			return kk.respErr(js)
+		}
+
+		ticker, err := kk.result(js)
+		if err != nil {
+			return nil, err
+		}
+		price, err := toFloat(ticker.Get("c").GetIndex(0).Interface())
+		if err != nil {
+			return nil, err
+		}
+		return Price{price}, nil
+	}
+
+	p, err := ProcessResp(status, js, respOk, kk.respErr)
+	if err == nil {
+		price = p.(Price)
+	}
 	return
 }
 
@@ -78,13 +233,33 @@ func (kk *Kraken) GetSymbols() (symbols []string, err error) {
 	}
 
 	respOk := func(js *Json) (interface{}, error) {
+		if reasons, _ := js.Get("error").Array(); len(reasons) > 0 {
+			return kk.respErr(js)
+		}
+
 		var s []string
-		data, _ := js.Get("result").Map()
-		for _, d := range data {
-			dd := d.(map[string]interface{})
-			base := strings.ToLower(dd["base"].(string))
-			quote := strings.ToLower(dd["quote"].(string))
-			s = append(s, base+"_"+quote)
+		data, err := js.Get("result").Map()
+		if err != nil {
+			return nil, errors.New("No result in response")
+		}
+		for name, d := range data {
+			// dark pool of the same pair
+			if strings.HasSuffix(name, ".d") {
+				continue
+			}
+			dd, err := toMap(d)
+			if err != nil {
+				return nil, err
+			}
+			base, err := toString(dd["base"])
+			if err != nil {
+				return nil, err
+			}
+			quote, err := toString(dd["quote"])
+			if err != nil {
+				return nil, err
+			}
+			s = append(s, krakenCurrency(base)+"_"+krakenCurrency(quote))
 		}
 		return s, nil
 	}
@@ -97,11 +272,47 @@ func (kk *Kraken) GetSymbols() (symbols []string, err error) {
 }
 
 func (kk *Kraken) GetDepth(cp *CurrencyPair) (depth Depth, err error) {
+	params := map[string][]string{
+		"pair": {kk.ToSymbol(cp)},
+	}
+	status, js, err := kk.sendReq("GET", "/0/public/Depth", params, false)
+	if err != nil {
+		return
+	}
+
+	respOk := func(js *Json) (interface{}, error) {
+		if reasons, _ := js.Get("error").Array(); len(reasons) > 0 {
+			return kk.respErr(js)
+		}
+
+		book, err := kk.result(js)
+		if err != nil {
+			return nil, err
+		}
+		depth, err := getDepth(book, "asks", "bids")
+		if err != nil {
+			return nil, err
+		}
+		return depth, nil
+	}
+
+	d, err := ProcessResp(status, js, respOk, kk.respErr)
+	if err == nil {
+		depth = d.(Depth)
+	}
 	return
 }
 
 func (kk *Kraken) OrderState(s interface{}) string {
-	return s.(string)
+	switch s {
+	case "pending", "open":
+		return Alive
+	case "canceled", "expired":
+		return Cancelled
+	case "closed":
+		return Filled
+	}
+	return Unknown
 }
 
 func (kk *Kraken) OrderSide(s string) string {
@@ -109,17 +320,123 @@ func (kk *Kraken) OrderSide(s string) string {
 }
 
 func (kk *Kraken) NewOrder(o *Order) (id string, err error) {
+	params := map[string][]string{
+		"pair":      {kk.ToSymbol(&o.CP)},
+		"type":      {o.Side},
+		"ordertype": {"limit"},
+		"price":     {strconv.FormatFloat(o.Price, 'f', -1, 64)},
+		"volume":    {strconv.FormatFloat(o.Amount, 'f', -1, 64)},
+	}
+
+	status, js, err := kk.sendReq("POST", "/0/private/AddOrder", params, true)
+	if err != nil {
+		return
+	}
+
+	respOk := func(js *Json) (interface{}, error) {
+		if reasons, _ := js.Get("error").Array(); len(reasons) > 0 {
+			return kk.respErr(js)
+		}
+
+		id, _ := js.Get("result").Get("txid").GetIndex(0).String()
+		if id == "" {
+			return nil, errors.New("No order id")
+		}
+		return id, nil
+	}
+
+	oid, err := ProcessResp(status, js, respOk, kk.respErr)
+	if err == nil {
+		id = oid.(string)
+	}
 	return
 }
 
 func (kk *Kraken) CancelOrder(o *Order) (err error) {
+	params := map[string][]string{
+		"txid": {o.Id},
+	}
+
+	status, js, err := kk.sendReq("POST", "/0/private/CancelOrder", params, true)
+	if err != nil {
+		return
+	}
+
+	respOk := func(js *Json) (interface{}, error) {
+		if reasons, _ := js.Get("error").Array(); len(reasons) > 0 {
+			return kk.respErr(js)
+		}
+		return nil, nil
+	}
+
+	_, err = ProcessResp(status, js, respOk, kk.respErr)
 	return
 }
 
 func (kk *Kraken) QueryOrder(o *Order) (order Order, err error) {
+	params := map[string][]string{
+		"txid": {o.Id},
+	}
+
+	status, js, err := kk.sendReq("POST", "/0/private/QueryOrders", params, true)
+	if err != nil {
+		return
+	}
+
+	respOk := func(js *Json) (interface{}, error) {
+		if reasons, _ := js.Get("error").Array(); len(reasons) > 0 {
+			return kk.respErr(js)
+		}
+
+		var order Order
+		od, ok := js.Get("result").CheckGet(o.Id)
+		if !ok {
+			return nil, errors.New("No order " + o.Id)
+		}
+		order.Id = o.Id
+		descr := od.Get("descr")
+		symbol, err := getString(descr, "pair")
+		if err != nil {
+			return nil, err
+		}
+		order.CP = NewCurrencyPair2(kk.NormSymbol(&symbol))
+		side, err := getString(descr, "type")
+		if err != nil {
+			return nil, err
+		}
+		order.Side = kk.OrderSide(side)
+		if order.Price, err = getFloat(descr, "price"); err != nil {
+			return nil, err
+		}
+		if order.Amount, err = getFloat(od, "vol"); err != nil {
+			return nil, err
+		}
+		if order.Executed, err = getFloat(od, "vol_exec"); err != nil {
+			return nil, err
+		}
+		order.Remain = order.Amount - order.Executed
+		state, err := getString(od, "status")
+		if err != nil {
+			return nil, err
+		}
+		order.State = kk.OrderState(state)
+		return order, nil
+	}
+
+	od, err := ProcessResp(status, js, respOk, kk.respErr)
+	if err == nil {
+		order = od.(Order)
+	}
 	return
 }
 
+func (kk *Kraken) Capabilities() Capabilities {
+	return Capabilities{
+		Operations: BasicOperations,
+		OrderTypes: []string{LimitOrder},
+	}
+}
+
 func NewKraken() Exchange {
 	return new(Kraken)
 }
@@ -1,10 +1,14 @@
 package lib
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	. "github.com/bitly/go-simplejson"
@@ -26,14 +30,37 @@ type Unit struct {
 	Amount float64
 }
 
+// Best price first: Asks ascending, Bids descending
 type Depth struct {
 	Bids []Unit
 	Asks []Unit
 }
 
+type Ticker struct {
+	Last, Buy, Sell float64
+	High, Low       float64
+	Volume          float64
+	Time            time.Time
+}
+
+type Kline struct {
+	Time                   time.Time
+	Open, High, Low, Close float64
+	Volume                 float64
+}
+
+type Trade struct {
+	Time   time.Time
+	Price  float64
+	Amount float64
+	Side   string
+}
+
+// Order states every exchange maps its own to
 const (
 	Alive     = "Alive"
 	Cancelled = "Cancelled"
+	Filled    = "Filled"
 	Unknown   = "Unknown"
 )
 
@@ -46,7 +73,73 @@ type Order struct {
 	State                    string
 }
 
+// Operations an exchange may support, named after the cli commands
+const (
+	OpPrice       = "price"
+	OpSymbols     = "symbols"
+	OpDepth       = "depth"
+	OpBalance     = "balance"
+	OpNewOrder    = "neworder"
+	OpCancelOrder = "cancelorder"
+	OpQueryOrder  = "queryorder"
+	OpTicker      = "ticker"
+	OpTrades      = "trades"
+	OpKline       = "kline"
+)
+
+var (
+	// Operations of the Exchange interface
+	BasicOperations = []string{OpPrice, OpSymbols, OpDepth, OpBalance,
+		OpNewOrder, OpCancelOrder, OpQueryOrder}
+	// Operations of the MarketData interface
+	MarketDataOperations = []string{OpTicker, OpTrades, OpKline}
+	AllOperations        = append(append([]string(nil), BasicOperations...),
+		MarketDataOperations...)
+)
+
+// Order types and features
+const (
+	LimitOrder  = "limit"
+	MarketOrder = "market" // Order with Price 0
+
+	ClientOrderId = "client-order-id" // order id is chosen by bcex
+	Simulated     = "simulated"       // orders never reach an exchange
+	Hidden        = "hidden"          // not listed, an exchange for tests
+)
+
+// Capabilities describes what an exchange supports through bcex. Streams
+// are the push feeds it offers, none so far.
+type Capabilities struct {
+	Operations []string
+	OrderTypes []string
+	Streams    []string
+	Features   []string
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (c Capabilities) Has(op string) bool {
+	return contains(c.Operations, op)
+}
+
+func (c Capabilities) HasOrderType(t string) bool {
+	return contains(c.OrderTypes, t)
+}
+
+func (c Capabilities) HasFeature(f string) bool {
+	return contains(c.Features, f)
+}
+
 type Exchange interface {
+	Capabilities() Capabilities
+
 	ToSymbol(cp *CurrencyPair) string
 	NormSymbol(cp *string) string
 
@@ -65,26 +158,82 @@ type Exchange interface {
 	QueryOrder(o *Order) (Order, error)
 }
 
+// ErrNotImplemented is returned by a method an exchange doesn't support.
+var ErrNotImplemented = errors.New("Not implemented")
+
+// MarketData is implemented by exchanges which serve more market data than
+// the price and depth. Period of klines is one of 1m, 5m, 15m, 30m, 1h, 2h,
+// 4h, 6h, 12h, 1d and 1w.
+type MarketData interface {
+	GetTicker(cp *CurrencyPair) (Ticker, error)
+	GetTrades(cp *CurrencyPair) ([]Trade, error)
+	GetKlines(cp *CurrencyPair, period string) ([]Kline, error)
+}
+
 type NewExchange func() Exchange
 
+// WrapExchange builds an exchange on top of exchange ex registered as name.
+type WrapExchange func(name string, ex Exchange) Exchange
+
 var exs = map[string]NewExchange{}
+var wrappers = map[string]WrapExchange{}
 
-func RegisterEx(name string, ne NewExchange) {
+// RegisterEx registers exchange name, fields are the credentials it signs
+// with if they are not DefaultCredentials.
+func RegisterEx(name string, ne NewExchange, fields ...CredentialField) {
 	if ne != nil {
 		exs[name] = ne
+		if len(fields) > 0 {
+			credentials[name] = fields
+		}
+	}
+}
+
+// RegisterWrapper makes "name:EX" available for every registered EX.
+func RegisterWrapper(name string, we WrapExchange) {
+	if we != nil {
+		wrappers[name] = we
 	}
 }
 
 func GetEx(name string) Exchange {
+	if i := strings.Index(name, ":"); i > 0 {
+		we, ok := wrappers[name[:i]]
+		if !ok {
+			return nil
+		}
+		if ex := GetEx(name[i+1:]); ex != nil {
+			return we(name[i+1:], ex)
+		}
+		return nil
+	}
 	if ne, ok := exs[name]; ok {
 		return ne()
 	}
 	return nil
 }
 
+// FindEx lists the exchanges which support all of ops.
+func FindEx(ops ...string) (exchanges []string) {
+	for _, name := range ListEx() {
+		caps := GetEx(name).Capabilities()
+		found := true
+		for _, op := range ops {
+			found = found && caps.Has(op)
+		}
+		if found {
+			exchanges = append(exchanges, name)
+		}
+	}
+	return
+}
+
+// ListEx lists the registered exchanges but the Hidden ones.
 func ListEx() (exchanges []string) {
-	for key, _ := range exs {
-		exchanges = append(exchanges, key)
+	for key, ne := range exs {
+		if !ne().Capabilities().HasFeature(Hidden) {
+			exchanges = append(exchanges, key)
+		}
 	}
 	sort.Slice(exchanges, func(i, j int) bool {
 		return exchanges[i] < exchanges[j]
@@ -92,9 +241,14 @@ func ListEx() (exchanges []string) {
 	return
 }
 
+// Transport sends every request to the exchanges, nil means
+// http.DefaultTransport. Tests replace it with a Cassette.
+var Transport http.RoundTripper
+
 func recvResp(req *http.Request) (int, *Json, error) {
 	client := &http.Client{
-		Timeout: 15 * time.Second,
+		Timeout:   15 * time.Second,
+		Transport: Transport,
 	}
 	resp, err := client.Do(req)
 	if err != nil {
@@ -123,3 +277,156 @@ func ProcessResp(status int, js *Json, respOk, respErr RespHandle) (interface{},
 		return respErr(js)
 	}
 }
+
+/*
+ * Handlers take a response apart with the helpers below, which return an
+ * error for a value not in the documented form, so a changed response is
+ * reported rather than bringing the caller down.
+ */
+
+// toFloat parses a number of a response, which exchanges send as json
+// number or string
+func toFloat(v interface{}) (float64, error) {
+	switch n := v.(type) {
+	case json.Number:
+		return n.Float64()
+	case string:
+		return strconv.ParseFloat(n, 64)
+	case float64:
+		return n, nil
+	}
+	return 0, fmt.Errorf("Unexpected number %v in response", v)
+}
+
+func toString(v interface{}) (string, error) {
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	return "", fmt.Errorf("Unexpected string %v in response", v)
+}
+
+func toMap(v interface{}) (map[string]interface{}, error) {
+	if m, ok := v.(map[string]interface{}); ok {
+		return m, nil
+	}
+	return nil, fmt.Errorf("Unexpected object %v in response", v)
+}
+
+func toArray(v interface{}) ([]interface{}, error) {
+	if a, ok := v.([]interface{}); ok {
+		return a, nil
+	}
+	return nil, fmt.Errorf("Unexpected array %v in response", v)
+}
+
+// getFloat parses number key of object js
+func getFloat(js *Json, key string) (float64, error) {
+	v, ok := js.CheckGet(key)
+	if !ok {
+		return 0, fmt.Errorf("No %s in response", key)
+	}
+	return toFloat(v.Interface())
+}
+
+// getString returns string key of object js
+func getString(js *Json, key string) (string, error) {
+	v, ok := js.CheckGet(key)
+	if !ok {
+		return "", fmt.Errorf("No %s in response", key)
+	}
+	return toString(v.Interface())
+}
+
+// getId returns id key of object js, which exchanges send as json number
+// or string
+func getId(js *Json, key string) (string, error) {
+	switch id := js.Get(key).Interface().(type) {
+	case json.Number:
+		return id.String(), nil
+	case string:
+		if id != "" {
+			return id, nil
+		}
+	}
+	return "", fmt.Errorf("No %s in response", key)
+}
+
+// toUnits converts the levels of a depth side, each [price, amount, ...]
+func toUnits(v interface{}) ([]Unit, error) {
+	levels, err := toArray(v)
+	if err != nil {
+		return nil, err
+	}
+	var units []Unit
+	for _, l := range levels {
+		ll, err := toArray(l)
+		if err != nil {
+			return nil, err
+		}
+		if len(ll) < 2 {
+			return nil, fmt.Errorf("Unexpected level %v in response", l)
+		}
+		var u Unit
+		if u.Price, err = toFloat(ll[0]); err != nil {
+			return nil, err
+		}
+		if u.Amount, err = toFloat(ll[1]); err != nil {
+			return nil, err
+		}
+		units = append(units, u)
+	}
+	return units, nil
+}
+
+// toUnitsOf converts the levels of a depth side, each an object with the
+// price and amount at keys price and amount
+func toUnitsOf(v interface{}, price, amount string) ([]Unit, error) {
+	levels, err := toArray(v)
+	if err != nil {
+		return nil, err
+	}
+	var units []Unit
+	for _, l := range levels {
+		ll, err := toMap(l)
+		if err != nil {
+			return nil, err
+		}
+		var u Unit
+		if u.Price, err = toFloat(ll[price]); err != nil {
+			return nil, err
+		}
+		if u.Amount, err = toFloat(ll[amount]); err != nil {
+			return nil, err
+		}
+		units = append(units, u)
+	}
+	return units, nil
+}
+
+// reverseUnits turns the levels of units around, for asks sent from the
+// highest price
+func reverseUnits(units []Unit) []Unit {
+	for i, j := 0, len(units)-1; i < j; i, j = i+1, j-1 {
+		units[i], units[j] = units[j], units[i]
+	}
+	return units
+}
+
+// getDepth converts the asks and bids arrays of object js
+func getDepth(js *Json, asks, bids string) (depth Depth, err error) {
+	if depth.Asks, err = toUnits(js.Get(asks).Interface()); err != nil {
+		return
+	}
+	depth.Bids, err = toUnits(js.Get(bids).Interface())
+	return
+}
+
+// failedCode reports errors returned with status 200, an error field or a
+// code other than success
+func failedCode(js *Json, success string) bool {
+	if _, ok := js.CheckGet("error"); ok {
+		return true
+	}
+	code, ok := js.CheckGet("code")
+	return ok && fmt.Sprint(code.Interface()) != success
+}
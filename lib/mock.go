@@ -0,0 +1,342 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+ * Mock is an in-process exchange for tests, no request leaves the process.
+ *
+ * Market data is scripted with SetSymbols, SetPrice and SetDepth. Orders
+ * are kept in a SimAccount and matched against the scripted depth when
+ * placed and whenever the depth changes, what they take is gone from the
+ * book until SetDepth scripts it again. Failures are injected per method
+ * name ("GetBalance", "NewOrder", ...) with FailNext, TimeoutNext and
+ * ErrorNext, and SetPartialFill leaves only part of the book to orders.
+ *
+ * The exchange registered as "mock" is DefaultMock, so what a test scripts
+ * there is what the cli commands see. It is Hidden, list doesn't show it.
+ */
+
+type MockError struct {
+	Code    int
+	Message string
+}
+
+func (e *MockError) Error() string {
+	return fmt.Sprintf("%d: %s", e.Code, e.Message)
+}
+
+type mockTimeout struct{}
+
+func (mockTimeout) Error() string   { return "mock: request timeout" }
+func (mockTimeout) Timeout() bool   { return true }
+func (mockTimeout) Temporary() bool { return true }
+
+// ErrMockTimeout is a net.Error reporting a timeout, as the http client does.
+var ErrMockTimeout error = mockTimeout{}
+
+type Mock struct {
+	mu       sync.Mutex
+	Account  *SimAccount
+	Calls    []string
+	symbols  []string
+	prices   map[string]float64
+	depths   map[string]Depth
+	failures map[string][]error
+	partial  float64
+	delay    time.Duration
+
+	accesskeyid, secretkeyid string
+}
+
+var DefaultMock = NewMock()
+
+func NewMock() *Mock {
+	m := new(Mock)
+	m.Reset()
+	return m
+}
+
+// Reset drops all scripted data, orders, balances and failures.
+func (m *Mock) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Account = NewSimAccount(0)
+	m.Calls = nil
+	m.symbols = nil
+	m.prices = map[string]float64{}
+	m.depths = map[string]Depth{}
+	m.failures = map[string][]error{}
+	m.partial = 0
+	m.delay = 0
+	m.accesskeyid, m.secretkeyid = "", ""
+}
+
+func mockKey(cp *CurrencyPair) string {
+	return strings.ToLower(cp.String())
+}
+
+func (m *Mock) SetSymbols(symbols ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.symbols = symbols
+}
+
+func (m *Mock) SetPrice(cp CurrencyPair, price float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.prices[mockKey(&cp)] = price
+}
+
+// SetDepth replaces the book of cp and matches the open orders against it.
+func (m *Mock) SetDepth(cp CurrencyPair, depth Depth) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.depths[mockKey(&cp)] = depth
+	delete(m.Account.Taken, mockKey(&cp))
+	m.match(&cp)
+}
+
+// SetPartialFill leaves only ratio of each book level to the orders, 0
+// means the whole level.
+func (m *Mock) SetPartialFill(ratio float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.partial = ratio
+}
+
+// SetDelay makes every call take d before it returns.
+func (m *Mock) SetDelay(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.delay = d
+}
+
+func (m *Mock) Deposit(currency string, amount float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Account.Deposit(currency, amount)
+}
+
+// Fill executes amount of an open order at price, as the market would.
+func (m *Mock) Fill(id string, price, amount float64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.Account.Fill(id, price, amount, time.Now())
+}
+
+// FailNext makes the next call of method return err, failures queue up.
+func (m *Mock) FailNext(method string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failures[method] = append(m.failures[method], err)
+}
+
+func (m *Mock) TimeoutNext(method string) {
+	m.FailNext(method, ErrMockTimeout)
+}
+
+func (m *Mock) ErrorNext(method string, code int, message string) {
+	m.FailNext(method, &MockError{code, message})
+}
+
+// Key returns the credentials the exchange was given by SetKey.
+func (m *Mock) Key() (access, secret string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.accesskeyid, m.secretkeyid
+}
+
+// call records method and returns the failure injected for it, if any.
+// The caller holds m.mu.
+func (m *Mock) call(method string) error {
+	m.Calls = append(m.Calls, method)
+	if m.delay > 0 {
+		time.Sleep(m.delay)
+	}
+	if errs := m.failures[method]; len(errs) > 0 {
+		m.failures[method] = errs[1:]
+		return errs[0]
+	}
+	return nil
+}
+
+func (m *Mock) match(cp *CurrencyPair) {
+	depth, ok := m.depths[mockKey(cp)]
+	if !ok {
+		return
+	}
+	if m.partial > 0 {
+		var limited Depth
+		for _, u := range depth.Asks {
+			limited.Asks = append(limited.Asks, Unit{u.Price, u.Amount * m.partial})
+		}
+		for _, u := range depth.Bids {
+			limited.Bids = append(limited.Bids, Unit{u.Price, u.Amount * m.partial})
+		}
+		depth = limited
+	}
+	m.Account.Match(cp, depth, time.Now())
+}
+
+// leftUnits returns the levels of units with what orders took from them
+// removed, empty levels are dropped
+func leftUnits(units, taken []Unit) (left []Unit) {
+	for _, u := range units {
+		for _, t := range taken {
+			if t.Price == u.Price {
+				u.Amount -= t.Amount
+			}
+		}
+		if u.Amount > 0 {
+			left = append(left, u)
+		}
+	}
+	return
+}
+
+func (m *Mock) Capabilities() Capabilities {
+	return Capabilities{
+		Operations: BasicOperations,
+		OrderTypes: []string{LimitOrder},
+		Features:   []string{Simulated, Hidden},
+	}
+}
+
+func (m *Mock) ToSymbol(cp *CurrencyPair) string {
+	return mockKey(cp)
+}
+
+func (m *Mock) NormSymbol(cp *string) string {
+	return *cp
+}
+
+func (m *Mock) OrderState(s interface{}) string {
+	if state, ok := s.(string); ok {
+		return state
+	}
+	return Unknown
+}
+
+func (m *Mock) OrderSide(s string) string {
+	return s
+}
+
+func (m *Mock) SetKey(access, secret string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.accesskeyid = access
+	m.secretkeyid = secret
+}
+
+func (m *Mock) GetPrice(cp *CurrencyPair) (price Price, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err = m.call("GetPrice"); err != nil {
+		return
+	}
+
+	if p, ok := m.prices[mockKey(cp)]; ok {
+		return Price{p}, nil
+	}
+	if d, ok := m.depths[mockKey(cp)]; ok && len(d.Asks) > 0 && len(d.Bids) > 0 {
+		ask := sortUnits(d.Asks, true)[0].Price
+		bid := sortUnits(d.Bids, false)[0].Price
+		return Price{(ask + bid) / 2}, nil
+	}
+	return price, errors.New("No price for " + cp.String())
+}
+
+func (m *Mock) GetSymbols() (symbols []string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err = m.call("GetSymbols"); err != nil {
+		return
+	}
+
+	if m.symbols != nil {
+		return append(symbols, m.symbols...), nil
+	}
+	seen := map[string]bool{}
+	for s, _ := range m.prices {
+		seen[s] = true
+	}
+	for s, _ := range m.depths {
+		seen[s] = true
+	}
+	for s, _ := range seen {
+		symbols = append(symbols, s)
+	}
+	sort.Strings(symbols)
+	return
+}
+
+func (m *Mock) GetDepth(cp *CurrencyPair) (depth Depth, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err = m.call("GetDepth"); err != nil {
+		return
+	}
+
+	d, ok := m.depths[mockKey(cp)]
+	if !ok {
+		return depth, errors.New("No depth for " + cp.String())
+	}
+	taken := m.Account.Taken[mockKey(cp)]
+	depth.Asks = leftUnits(d.Asks, taken.Asks)
+	depth.Bids = leftUnits(d.Bids, taken.Bids)
+	return
+}
+
+func (m *Mock) GetBalance() (balances []Balance, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err = m.call("GetBalance"); err != nil {
+		return
+	}
+	return m.Account.GetBalance(), nil
+}
+
+func (m *Mock) NewOrder(o *Order) (id string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err = m.call("NewOrder"); err != nil {
+		return
+	}
+
+	id, err = m.Account.Place(o)
+	if err == nil {
+		m.match(&o.CP)
+	}
+	return
+}
+
+func (m *Mock) CancelOrder(o *Order) (err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err = m.call("CancelOrder"); err != nil {
+		return
+	}
+	return m.Account.Cancel(o.Id)
+}
+
+func (m *Mock) QueryOrder(o *Order) (order Order, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err = m.call("QueryOrder"); err != nil {
+		return
+	}
+	return m.Account.Query(o.Id)
+}
+
+func init() {
+	RegisterEx("mock", func() Exchange {
+		return DefaultMock
+	})
+}
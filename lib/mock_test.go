@@ -0,0 +1,93 @@
+package lib
+
+import (
+	"math"
+	"net"
+	"reflect"
+	"testing"
+)
+
+var mockPair = NewCurrencyPair2("eth_btc")
+
+func newTestMock() *Mock {
+	m := NewMock()
+	m.Deposit("btc", 1)
+	m.SetDepth(mockPair, Depth{
+		Asks: []Unit{{0.05, 1}, {0.06, 2}},
+		Bids: []Unit{{0.04, 1}},
+	})
+	return m
+}
+
+func TestMockTakesDepth(t *testing.T) {
+	m := newTestMock()
+
+	id, err := m.NewOrder(&Order{CP: mockPair, Side: "buy", Price: 0.06, Amount: 0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o, _ := m.QueryOrder(&Order{Id: id}); o.State != Filled {
+		t.Errorf("order crossing the book is %s", o.State)
+	}
+
+	// the level at 0.05 has only 0.5 left for the next order
+	id, _ = m.NewOrder(&Order{CP: mockPair, Side: "buy", Price: 0.05, Amount: 1})
+	if o, _ := m.QueryOrder(&Order{Id: id}); o.State != Alive || o.Executed != 0.5 {
+		t.Errorf("order on a taken level = %+v", o)
+	}
+	depth, _ := m.GetDepth(&mockPair)
+	if want := []Unit{{0.06, 2}}; !reflect.DeepEqual(depth.Asks, want) {
+		t.Errorf("asks = %v, want %v", depth.Asks, want)
+	}
+
+	// scripting the book again brings the liquidity back
+	m.SetDepth(mockPair, Depth{Asks: []Unit{{0.05, 1}}})
+	if o, _ := m.QueryOrder(&Order{Id: id}); o.State != Filled {
+		t.Errorf("order after SetDepth is %s", o.State)
+	}
+	if btc := m.Account.Total("btc"); math.Abs(btc-0.925) > 1e-12 {
+		t.Errorf("btc = %v", btc)
+	}
+}
+
+func TestMockPartialFill(t *testing.T) {
+	m := newTestMock()
+	m.SetPartialFill(0.5)
+
+	id, _ := m.NewOrder(&Order{CP: mockPair, Side: "buy", Price: 0.05, Amount: 1})
+	m.SetDepth(mockPair, Depth{Asks: []Unit{{0.05, 1}}})
+	o, _ := m.QueryOrder(&Order{Id: id})
+	if o.State != Filled || len(m.Account.Fills) != 2 {
+		t.Errorf("order = %+v, fills %v", o, m.Account.Fills)
+	}
+}
+
+func TestMockFailures(t *testing.T) {
+	m := newTestMock()
+	m.ErrorNext("GetBalance", 1001, "busy")
+	m.TimeoutNext("GetBalance")
+
+	if _, err := m.GetBalance(); err == nil || err.(*MockError).Code != 1001 {
+		t.Errorf("first GetBalance = %v", err)
+	}
+	if _, err := m.GetBalance(); err == nil || !err.(net.Error).Timeout() {
+		t.Errorf("second GetBalance = %v", err)
+	}
+	if balances, err := m.GetBalance(); err != nil || len(balances) != 1 {
+		t.Errorf("third GetBalance = %v, %v", balances, err)
+	}
+	if want := []string{"GetBalance", "GetBalance", "GetBalance"}; !reflect.DeepEqual(m.Calls, want) {
+		t.Errorf("calls = %v", m.Calls)
+	}
+}
+
+func TestMockHidden(t *testing.T) {
+	if GetEx("mock") != Exchange(DefaultMock) {
+		t.Error("mock is not DefaultMock")
+	}
+	for _, name := range ListEx() {
+		if name == "mock" {
+			t.Error("mock is listed")
+		}
+	}
+}
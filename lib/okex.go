@@ -1,7 +1,6 @@
 package lib
 
 import (
-	"encoding/json"
 	"errors"
 	"net/http"
 	"net/url"
@@ -13,6 +12,9 @@ import (
 
 /*
  * Reference page: https://www.okex.com/rest_api.html
+ *
+ * This is the v1 API, signed with the access and secret key only. The
+ * passphrase of v3 API keys has no use here, it comes with a port to v3.
  */
 
 type Okex struct {
@@ -76,11 +78,19 @@ func (ok *Okex) GetBalance() (balances []Balance, err error) {
 	respOk := func(js *Json) (interface{}, error) {
 		result, _ := js.Get("result").Bool()
 		if result {
-			free, _ := js.Get("info").Get("funds").Get("free").Map()
+			free, err := js.Get("info").Get("funds").Get("free").Map()
+			if err != nil {
+				return nil, errors.New("No funds in response")
+			}
 			for cur, b := range free {
-				if b.(string) != "0" {
-					balances = append(balances,
-						Balance{cur, b.(string)})
+				balance, err := toString(b)
+				if err != nil {
+					return nil, err
+				}
+				if f, err := toFloat(balance); err != nil {
+					return nil, err
+				} else if f != 0 {
+					balances = append(balances, Balance{cur, balance})
 				}
 			}
 			return balances, nil
@@ -122,8 +132,10 @@ func (ok *Okex) GetPrice(cp *CurrencyPair) (price Price, err error) {
 			return nil, err
 		}
 
-		last_s, _ := js.Get("ticker").Get("last").String()
-		last, _ := strconv.ParseFloat(last_s, 64)
+		last, err := getFloat(js.Get("ticker"), "last")
+		if err != nil {
+			return nil, err
+		}
 		return Price{last}, nil
 	}
 
@@ -148,10 +160,19 @@ func (ok *Okex) GetSymbols() (symbols []string, err error) {
 		}
 
 		var s []string
-		data, _ := js.Get("data").Array()
+		data, err := toArray(js.Get("data").Interface())
+		if err != nil {
+			return nil, err
+		}
 		for _, d := range data {
-			dd := d.(map[string]interface{})
-			symbol := dd["symbol"].(string)
+			dd, err := toMap(d)
+			if err != nil {
+				return nil, err
+			}
+			symbol, err := toString(dd["symbol"])
+			if err != nil {
+				return nil, err
+			}
 			s = append(s, symbol)
 		}
 		return s, nil
@@ -175,21 +196,11 @@ func (ok *Okex) GetDepth(cp *CurrencyPair) (depth Depth, err error) {
 	}
 
 	respOk := func(js *Json) (interface{}, error) {
-		var depth Depth
-		asks, _ := js.Get("asks").Array()
-		for _, a := range asks {
-			uu := a.([]interface{})
-			price, _ := uu[0].(json.Number).Float64()
-			amount, _ := uu[1].(json.Number).Float64()
-			depth.Asks = append(depth.Asks, Unit{price, amount})
-		}
-		bids, _ := js.Get("bids").Array()
-		for _, b := range bids {
-			uu := b.([]interface{})
-			price, _ := uu[0].(json.Number).Float64()
-			amount, _ := uu[1].(json.Number).Float64()
-			depth.Bids = append(depth.Bids, Unit{price, amount})
+		depth, err := getDepth(js, "asks", "bids")
+		if err != nil {
+			return nil, err
 		}
+		depth.Asks = reverseUnits(depth.Asks)
 		return depth, nil
 	}
 
@@ -203,13 +214,14 @@ func (ok *Okex) GetDepth(cp *CurrencyPair) (depth Depth, err error) {
 func (ok *Okex) OrderState(s interface{}) string {
 	switch v := s.(type) {
 	case int:
-		if v == 0 {
+		switch v {
+		case 0, 1, 4:
 			return Alive
-		} else if v == -1 {
+		case -1:
 			return Cancelled
+		case 2:
+			return Filled
 		}
-	case string:
-		return v
 	}
 	return Unknown
 }
@@ -234,8 +246,7 @@ func (ok *Okex) NewOrder(o *Order) (id string, err error) {
 	respOk := func(js *Json) (interface{}, error) {
 		result, _ := js.Get("result").Bool()
 		if result {
-			id, _ := js.Get("order_id").Int64()
-			return strconv.FormatInt(id, 10), nil
+			return getId(js, "order_id")
 		} else {
 			code, _ := js.Get("error_code").Int64()
 			err = errors.New(ok.code2reason(code))
@@ -297,16 +308,30 @@ func (ok *Okex) QueryOrder(o *Order) (order Order, err error) {
 				return nil, errors.New("No valid order")
 			}
 			os := js.Get("orders").GetIndex(0)
-			id, _ := os.Get("order_id").Int64()
-			order.Id = strconv.FormatInt(id, 10)
+			var err error
+			if order.Id, err = getId(os, "order_id"); err != nil {
+				return nil, err
+			}
 			order.CP = o.CP
-			side, _ := os.Get("type").String()
+			side, err := getString(os, "type")
+			if err != nil {
+				return nil, err
+			}
 			order.Side = ok.OrderSide(side)
-			order.Price, _ = os.Get("price").Float64()
-			order.Amount, _ = os.Get("amount").Float64()
-			order.Executed, _ = os.Get("deal_amount").Float64()
+			if order.Price, err = getFloat(os, "price"); err != nil {
+				return nil, err
+			}
+			if order.Amount, err = getFloat(os, "amount"); err != nil {
+				return nil, err
+			}
+			if order.Executed, err = getFloat(os, "deal_amount"); err != nil {
+				return nil, err
+			}
 			order.Remain = order.Amount - order.Executed
-			status, _ := os.Get("status").Int()
+			status, err := os.Get("status").Int()
+			if err != nil {
+				return nil, errors.New("No status in response")
+			}
 			order.State = ok.OrderState(status)
 			return order, nil
 		} else {
@@ -323,6 +348,13 @@ func (ok *Okex) QueryOrder(o *Order) (order Order, err error) {
 	return
 }
 
+func (ok *Okex) Capabilities() Capabilities {
+	return Capabilities{
+		Operations: BasicOperations,
+		OrderTypes: []string{LimitOrder},
+	}
+}
+
 func NewOkex() Exchange {
 	return new(Okex)
 }
@@ -2,14 +2,20 @@ package lib
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	. "github.com/bitly/go-simplejson"
 )
 
+/*
+ * Reference page: https://bb.otcbtc.com/documents/api_v2
+ */
+
 type OCTBTC struct {
 	accesskeyid, secretkeyid string
 }
@@ -75,13 +81,28 @@ func (otc *OCTBTC) GetBalance() (balances []Balance, err error) {
 	}
 
 	respOk := func(js *Json) (interface{}, error) {
-		bs, _ := js.Get("accounts").Array()
+		bs, err := toArray(js.Get("accounts").Interface())
+		if err != nil {
+			return nil, err
+		}
 		for _, b := range bs {
-			bt := b.(map[string]interface{})
-			if bt["balance"].(string) != "0.0" {
+			bt, err := toMap(b)
+			if err != nil {
+				return nil, err
+			}
+			currency, err := toString(bt["currency"])
+			if err != nil {
+				return nil, err
+			}
+			balance, err := toString(bt["balance"])
+			if err != nil {
+				return nil, err
+			}
+			if f, err := toFloat(balance); err != nil {
+				return nil, err
+			} else if f != 0 {
 				balances = append(balances,
-					Balance{Currency: bt["currency"].(string),
-						Balance: bt["balance"].(string)})
+					Balance{Currency: currency, Balance: balance})
 			}
 		}
 		return balances, nil
@@ -96,6 +117,163 @@ func (otc *OCTBTC) GetBalance() (balances []Balance, err error) {
 }
 
 func (otc *OCTBTC) GetPrice(cp *CurrencyPair) (price Price, err error) {
+	ticker, err := otc.GetTicker(cp)
+	if err == nil {
+		price.Price = ticker.Last
+	}
+	return
+}
+
+func (otc *OCTBTC) GetTicker(cp *CurrencyPair) (ticker Ticker, err error) {
+	status, js, err := otc.sendReq("GET", "/api/v2/tickers/"+otc.ToSymbol(cp), nil, false)
+	if err != nil {
+		return
+	}
+
+	respOk := func(js *Json) (interface{}, error) {
+		tk, ok := js.CheckGet("ticker")
+		if !ok {
+			return nil, errors.New("No ticker")
+		}
+		var ticker Ticker
+		fields := map[string]*float64{
+			"last": &ticker.Last, "buy": &ticker.Buy, "sell": &ticker.Sell,
+			"high": &ticker.High, "low": &ticker.Low, "vol": &ticker.Volume,
+		}
+		for key, f := range fields {
+			var err error
+			if *f, err = getFloat(tk, key); err != nil {
+				return nil, err
+			}
+		}
+		at, err := getFloat(js, "at")
+		if err != nil {
+			return nil, err
+		}
+		ticker.Time = time.Unix(int64(at), 0)
+		if ticker.Last <= 0 {
+			return nil, errors.New("No price")
+		}
+		return ticker, nil
+	}
+
+	t, err := ProcessResp(status, js, respOk, otc.respErr)
+	if err == nil {
+		ticker = t.(Ticker)
+	}
+	return
+}
+
+// trend of a trade is up if the taker buys
+func (otc *OCTBTC) tradeSide(s string) string {
+	switch s {
+	case "up", "bid":
+		return "buy"
+	case "down", "ask":
+		return "sell"
+	}
+	return s
+}
+
+func (otc *OCTBTC) GetTrades(cp *CurrencyPair) (trades []Trade, err error) {
+	params := map[string][]string{
+		"market": {otc.ToSymbol(cp)},
+	}
+	status, js, err := otc.sendReq("GET", "/api/v2/trades", params, false)
+	if err != nil {
+		return
+	}
+
+	respOk := func(js *Json) (interface{}, error) {
+		var trades []Trade
+		ts, err := toArray(js.Interface())
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range ts {
+			tt, err := toMap(t)
+			if err != nil {
+				return nil, err
+			}
+			var trade Trade
+			if trade.Price, err = toFloat(tt["price"]); err != nil {
+				return nil, err
+			}
+			if trade.Amount, err = toFloat(tt["volume"]); err != nil {
+				return nil, err
+			}
+			created, err := toString(tt["created_at"])
+			if err != nil {
+				return nil, err
+			}
+			if trade.Time, err = time.Parse(time.RFC3339, created); err != nil {
+				return nil, err
+			}
+			if side, ok := tt["side"].(string); ok {
+				trade.Side = otc.tradeSide(side)
+			}
+			trades = append(trades, trade)
+		}
+		return trades, nil
+	}
+
+	t, err := ProcessResp(status, js, respOk, otc.respErr)
+	if err == nil {
+		trades = t.([]Trade)
+	}
+	return
+}
+
+// minutes of each kline period
+var otcbtcPeriods = map[string]int{
+	"1m": 1, "5m": 5, "15m": 15, "30m": 30, "1h": 60, "2h": 120,
+	"4h": 240, "6h": 360, "12h": 720, "1d": 1440, "1w": 10080,
+}
+
+func (otc *OCTBTC) GetKlines(cp *CurrencyPair, period string) (klines []Kline, err error) {
+	minutes, ok := otcbtcPeriods[period]
+	if !ok {
+		return nil, errors.New("Unsupported period " + period)
+	}
+	params := map[string][]string{
+		"market": {otc.ToSymbol(cp)},
+		"period": {strconv.Itoa(minutes)},
+	}
+	status, js, err := otc.sendReq("GET", "/api/v2/klines", params, false)
+	if err != nil {
+		return
+	}
+
+	respOk := func(js *Json) (interface{}, error) {
+		var klines []Kline
+		ks, err := toArray(js.Interface())
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range ks {
+			kk, err := toArray(k)
+			if err != nil {
+				return nil, err
+			}
+			var fs [6]float64
+			if len(kk) < len(fs) {
+				return nil, fmt.Errorf("Unexpected kline %v in response", k)
+			}
+			for i := range fs {
+				if fs[i], err = toFloat(kk[i]); err != nil {
+					return nil, err
+				}
+			}
+			klines = append(klines, Kline{time.Unix(int64(fs[0]), 0),
+				fs[1], fs[2], fs[3], fs[4], fs[5]})
+		}
+		return klines, nil
+	}
+
+	k, err := ProcessResp(status, js, respOk, otc.respErr)
+	if err == nil {
+		klines = k.([]Kline)
+	}
 	return
 }
 
@@ -107,11 +285,20 @@ func (otc *OCTBTC) GetSymbols() (symbols []string, err error) {
 
 	respOk := func(js *Json) (interface{}, error) {
 		var s []string
-		data, _ := js.Array()
+		data, err := toArray(js.Interface())
+		if err != nil {
+			return nil, err
+		}
 		for _, d := range data {
-			dd := d.(map[string]interface{})
-			symbol := strings.ToLower(dd["ticker_id"].(string))
-			s = append(s, symbol)
+			dd, err := toMap(d)
+			if err != nil {
+				return nil, err
+			}
+			symbol, err := toString(dd["ticker_id"])
+			if err != nil {
+				return nil, err
+			}
+			s = append(s, strings.ToLower(symbol))
 		}
 		return s, nil
 	}
@@ -134,19 +321,12 @@ func (otc *OCTBTC) GetDepth(cp *CurrencyPair) (depth Depth, err error) {
 
 	respOk := func(js *Json) (interface{}, error) {
 		var depth Depth
-		asks, _ := js.Get("asks").Array()
-		for _, a := range asks {
-			uu := a.(map[string]interface{})
-			price, _ := strconv.ParseFloat(uu["price"].(string), 64)
-			amount, _ := strconv.ParseFloat(uu["volume"].(string), 64)
-			depth.Asks = append([]Unit{Unit{price, amount}}, depth.Asks...)
+		var err error
+		if depth.Asks, err = toUnitsOf(js.Get("asks").Interface(), "price", "volume"); err != nil {
+			return nil, err
 		}
-		bids, _ := js.Get("bids").Array()
-		for _, b := range bids {
-			uu := b.(map[string]interface{})
-			price, _ := strconv.ParseFloat(uu["price"].(string), 64)
-			amount, _ := strconv.ParseFloat(uu["volume"].(string), 64)
-			depth.Bids = append(depth.Bids, Unit{price, amount})
+		if depth.Bids, err = toUnitsOf(js.Get("bids").Interface(), "price", "volume"); err != nil {
+			return nil, err
 		}
 		return depth, nil
 	}
@@ -159,12 +339,15 @@ func (otc *OCTBTC) GetDepth(cp *CurrencyPair) (depth Depth, err error) {
 }
 
 func (otc *OCTBTC) OrderState(s interface{}) string {
-	if s.(string) == "wait" {
+	switch s {
+	case "wait":
 		return Alive
-	} else if s.(string) == "cancel" {
+	case "cancel":
 		return Cancelled
+	case "done":
+		return Filled
 	}
-	return s.(string)
+	return Unknown
 }
 
 func (otc *OCTBTC) OrderSide(s string) string {
@@ -186,8 +369,7 @@ func (otc *OCTBTC) NewOrder(o *Order) (id string, err error) {
 	}
 
 	respOk := func(js *Json) (interface{}, error) {
-		id, _ := js.Get("id").Int64()
-		return strconv.FormatInt(id, 10), nil
+		return getId(js, "id")
 	}
 
 	oid, err := ProcessResp(status, js, respOk, otc.respErr)
@@ -213,7 +395,6 @@ func (otc *OCTBTC) CancelOrder(o *Order) (err error) {
 
 	_, err = ProcessResp(status, js, respOk, otc.respErr)
 	return
-	return
 }
 
 func (otc *OCTBTC) QueryOrder(o *Order) (order Order, err error) {
@@ -228,19 +409,32 @@ func (otc *OCTBTC) QueryOrder(o *Order) (order Order, err error) {
 
 	respOk := func(js *Json) (interface{}, error) {
 		var order Order
-		id, _ := js.Get("id").Int64()
-		order.Id = strconv.FormatInt(id, 10)
-		market, _ := js.Get("market").String()
+		var err error
+		if order.Id, err = getId(js, "id"); err != nil {
+			return nil, err
+		}
+		market, err := getString(js, "market")
+		if err != nil {
+			return nil, err
+		}
 		order.CP = NewCurrencyPair2(otc.NormSymbol(&market))
-		order.Side, _ = js.Get("side").String()
-		price, _ := js.Get("price").String()
-		order.Price, _ = strconv.ParseFloat(price, 64)
-		amount, _ := js.Get("volume").String()
-		order.Amount, _ = strconv.ParseFloat(amount, 64)
-		executed, _ := js.Get("executed_volume").String()
-		order.Executed, _ = strconv.ParseFloat(executed, 64)
+		if order.Side, err = getString(js, "side"); err != nil {
+			return nil, err
+		}
+		if order.Price, err = getFloat(js, "price"); err != nil {
+			return nil, err
+		}
+		if order.Amount, err = getFloat(js, "volume"); err != nil {
+			return nil, err
+		}
+		if order.Executed, err = getFloat(js, "executed_volume"); err != nil {
+			return nil, err
+		}
 		order.Remain = order.Amount - order.Executed
-		status, _ := js.Get("state").String()
+		status, err := getString(js, "state")
+		if err != nil {
+			return nil, err
+		}
 		order.State = otc.OrderState(status)
 		return order, nil
 	}
@@ -252,6 +446,13 @@ func (otc *OCTBTC) QueryOrder(o *Order) (order Order, err error) {
 	return
 }
 
+func (otc *OCTBTC) Capabilities() Capabilities {
+	return Capabilities{
+		Operations: AllOperations,
+		OrderTypes: []string{LimitOrder},
+	}
+}
+
 func NewOTCBTC() Exchange {
 	return new(OCTBTC)
 }
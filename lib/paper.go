@@ -0,0 +1,166 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+/*
+ * Paper trading on top of a real exchange, selected as "paper:EX".
+ *
+ * Market data comes from the real venue, while orders and balances live
+ * in a virtual account saved to PaperDir. Open orders are filled whenever
+ * the live book crosses them, which is checked on every private call, and
+ * what they took is not taken again while the book still shows it.
+ */
+
+var (
+	PaperDir = "."
+	PaperFee = 0.001
+)
+
+type Paper struct {
+	Exchange
+	name    string
+	account *SimAccount
+}
+
+func (pp *Paper) path() string {
+	return filepath.Join(PaperDir, "paper_"+pp.name+".json")
+}
+
+func (pp *Paper) load() error {
+	pp.account = NewSimAccount(PaperFee)
+	raw, err := ioutil.ReadFile(pp.path())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, pp.account)
+}
+
+func (pp *Paper) save() error {
+	raw, err := json.MarshalIndent(pp.account, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(pp.path(), raw, 0600)
+}
+
+// match fills open orders against the live book of their pairs
+func (pp *Paper) match() error {
+	pairs := map[string]CurrencyPair{}
+	for _, o := range pp.account.Orders {
+		if o.State == Alive {
+			pairs[o.CP.String()] = o.CP
+		}
+	}
+
+	for _, cp := range pairs {
+		depth, err := pp.Exchange.GetDepth(&cp)
+		if err != nil {
+			return err
+		}
+		pp.account.Match(&cp, depth, time.Now())
+	}
+	return nil
+}
+
+// update loads the account, brings it up to date with the market, runs
+// op and saves the account.
+func (pp *Paper) update(op func() error) (err error) {
+	if err = pp.load(); err != nil {
+		return
+	}
+	if err = pp.match(); err != nil {
+		return
+	}
+	if op != nil {
+		if err = op(); err != nil {
+			return
+		}
+	}
+	return pp.save()
+}
+
+// Deposit adds amount of currency to the account, amount must be a
+// positive number.
+func (pp *Paper) Deposit(currency string, amount float64) error {
+	if !(amount > 0) || math.IsInf(amount, 1) {
+		return fmt.Errorf("Invalid deposit amount %v", amount)
+	}
+	return pp.update(func() error {
+		pp.account.Deposit(currency, amount)
+		return nil
+	})
+}
+
+func (pp *Paper) GetBalance() (balances []Balance, err error) {
+	err = pp.update(nil)
+	if err == nil {
+		balances = pp.account.GetBalance()
+	}
+	return
+}
+
+func (pp *Paper) NewOrder(o *Order) (id string, err error) {
+	err = pp.update(func() error {
+		id, err = pp.account.Place(o)
+		if err != nil {
+			return err
+		}
+		return pp.match()
+	})
+	return
+}
+
+func (pp *Paper) CancelOrder(o *Order) (err error) {
+	return pp.update(func() error {
+		return pp.account.Cancel(o.Id)
+	})
+}
+
+func (pp *Paper) QueryOrder(o *Order) (order Order, err error) {
+	err = pp.update(nil)
+	if err == nil {
+		order, err = pp.account.Query(o.Id)
+	}
+	return
+}
+
+// SetCredentials passes c to the real exchange
+func (pp *Paper) SetCredentials(c Credentials) error {
+	return ApplyCredentials(pp.Exchange, c)
+}
+
+// Capabilities are the market data of the real exchange and simulated
+// orders.
+func (pp *Paper) Capabilities() Capabilities {
+	caps := pp.Exchange.Capabilities()
+	var ops []string
+	for _, op := range BasicOperations {
+		if op != OpPrice && op != OpSymbols && op != OpDepth || caps.Has(op) {
+			ops = append(ops, op)
+		}
+	}
+	return Capabilities{
+		Operations: ops,
+		OrderTypes: []string{LimitOrder},
+		Streams:    caps.Streams,
+		Features:   []string{Simulated},
+	}
+}
+
+func NewPaper(name string, ex Exchange) Exchange {
+	return &Paper{Exchange: ex, name: name}
+}
+
+func init() {
+	RegisterWrapper("paper", NewPaper)
+}
@@ -0,0 +1,87 @@
+package lib
+
+import (
+	"math"
+	"testing"
+)
+
+// newTestPaper paper trades on a mock with 1 eth at 0.05 and 2 at 0.06
+func newTestPaper(t *testing.T) (*Paper, *Mock) {
+	dir, fee := PaperDir, PaperFee
+	t.Cleanup(func() { PaperDir, PaperFee = dir, fee })
+	PaperDir = t.TempDir()
+	PaperFee = 0
+	m := NewMock()
+	m.SetDepth(mockPair, Depth{
+		Asks: []Unit{{0.05, 1}, {0.06, 2}},
+		Bids: []Unit{{0.04, 1}},
+	})
+	pp := NewPaper("mock", m).(*Paper)
+	if err := pp.Deposit("btc", 1); err != nil {
+		t.Fatal(err)
+	}
+	return pp, m
+}
+
+func TestPaperOrder(t *testing.T) {
+	pp, m := newTestPaper(t)
+
+	// takes the level at 0.05 and rests for the rest
+	id, err := pp.NewOrder(&Order{CP: mockPair, Side: "buy", Price: 0.05, Amount: 1.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, _ := pp.QueryOrder(&Order{Id: id})
+	if o.State != Alive || o.Executed != 1 {
+		t.Fatalf("order = %+v", o)
+	}
+
+	// the live book still shows the level, which is not taken again
+	for i := 0; i < 3; i++ {
+		if o, _ = pp.QueryOrder(&Order{Id: id}); o.Executed != 1 {
+			t.Fatalf("order filled again: %+v", o)
+		}
+	}
+
+	// more comes to the level and fills the rest
+	m.SetDepth(mockPair, Depth{Asks: []Unit{{0.05, 1.8}}})
+	if o, _ = pp.QueryOrder(&Order{Id: id}); o.State != Filled {
+		t.Errorf("order = %+v", o)
+	}
+	balances, _ := pp.GetBalance()
+	want := []Balance{{"btc", "0.925"}, {"eth", "1.5"}}
+	if len(balances) != 2 || balances[0] != want[0] || balances[1] != want[1] {
+		t.Errorf("balances = %v, want %v", balances, want)
+	}
+}
+
+func TestPaperCancel(t *testing.T) {
+	pp, _ := newTestPaper(t)
+
+	id, _ := pp.NewOrder(&Order{CP: mockPair, Side: "buy", Price: 0.04, Amount: 10})
+	if err := pp.CancelOrder(&Order{Id: id}); err != nil {
+		t.Fatal(err)
+	}
+	if o, _ := pp.QueryOrder(&Order{Id: id}); o.State != Cancelled {
+		t.Errorf("order = %+v", o)
+	}
+	if pp.CancelOrder(&Order{Id: id}) == nil {
+		t.Error("cancelled order is cancelled again")
+	}
+	if balances, _ := pp.GetBalance(); len(balances) != 1 || balances[0] != (Balance{"btc", "1"}) {
+		t.Errorf("balances = %v", balances)
+	}
+}
+
+func TestPaperDeposit(t *testing.T) {
+	pp, _ := newTestPaper(t)
+
+	for _, amount := range []float64{0, -1, math.NaN(), math.Inf(1)} {
+		if pp.Deposit("btc", amount) == nil {
+			t.Errorf("deposit of %v is taken", amount)
+		}
+	}
+	if balances, _ := pp.GetBalance(); len(balances) != 1 || balances[0] != (Balance{"btc", "1"}) {
+		t.Errorf("balances = %v", balances)
+	}
+}
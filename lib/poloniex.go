@@ -1,22 +1,33 @@
 package lib
 
 import (
-	"encoding/json"
 	"errors"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	. "github.com/bitly/go-simplejson"
 )
 
+/*
+ * Reference page: https://docs.legacy.poloniex.com/
+ *
+ * Poloniex names a pair QUOTE_BASE, eth_btc is BTC_ETH.
+ */
+
 type Poloniex struct {
 	accesskeyid, secretkeyid string
 }
 
 func (p *Poloniex) respErr(js *Json) (interface{}, error) {
-	return nil, nil
+	reason, err := js.Get("error").String()
+	if err != nil {
+		return nil, errors.New(Unknown)
+	}
+	return nil, errors.New(reason)
 }
 
 func (p *Poloniex) ToSymbol(cp *CurrencyPair) string {
@@ -24,7 +35,11 @@ func (p *Poloniex) ToSymbol(cp *CurrencyPair) string {
 }
 
 func (p *Poloniex) NormSymbol(cp *string) string {
-	return *cp
+	currency := strings.Split(*cp, "_")
+	if len(currency) != 2 {
+		return strings.ToLower(*cp)
+	}
+	return strings.ToLower(currency[1] + "_" + currency[0])
 }
 
 func (p *Poloniex) sendReq(method, path string,
@@ -40,19 +55,18 @@ func (p *Poloniex) sendReq(method, path string,
 
 	req.URL, _ = url.Parse("https://poloniex.com" + path)
 	if sign {
-		sign_params := map[string][]string{
-			"ex_key": {p.accesskeyid},
+		q := url.Values{
+			"nonce": {strconv.FormatInt(time.Now().UnixNano(), 10)},
 		}
-
 		for k, v := range params {
-			sign_params[k] = v
+			q[k] = v
 		}
+		data := q.Encode()
 
-		q := req.URL.Query()
-		q = sign_params
-		data := q.Encode() + "&secret_key=" + p.secretkeyid
-		q.Add("sign", strings.ToUpper(GetMD5Hash(data)))
-		req.URL.RawQuery = q.Encode()
+		req.Header.Add("Key", p.accesskeyid)
+		req.Header.Add("Sign", ComputeHmac512(data, p.secretkeyid))
+		req.Body = ioutil.NopCloser(strings.NewReader(data))
+		req.ContentLength = int64(len(data))
 	} else {
 		q := req.URL.Query()
 		q = params
@@ -67,10 +81,77 @@ func (p *Poloniex) SetKey(access, secret string) {
 }
 
 func (p *Poloniex) GetBalance() (balances []Balance, err error) {
+	params := map[string][]string{
+		"command": {"returnBalances"},
+	}
+	status, js, err := p.sendReq("POST", "/tradingApi", params, true)
+	if err != nil {
+		return
+	}
+
+	respOk := func(js *Json) (interface{}, error) {
+		reason, err := js.Get("error").String()
+		if err == nil {
+			return nil, errors.New(reason)
+		}
+
+		var balances []Balance
+		data, err := js.Map()
+		if err != nil {
+			return nil, errors.New("No balances in response")
+		}
+		for currency, b := range data {
+			total, err := toFloat(b)
+			if err != nil {
+				return nil, err
+			}
+			if total == 0 {
+				continue
+			}
+			balances = append(balances,
+				Balance{Currency: strings.ToLower(currency),
+					Balance: strconv.FormatFloat(total, 'f', -1, 64)})
+		}
+		return balances, nil
+	}
+
+	b, err := ProcessResp(status, js, respOk, p.respErr)
+	if err == nil {
+		balances = b.([]Balance)
+	}
 	return
 }
 
 func (p *Poloniex) GetPrice(cp *CurrencyPair) (price Price, err error) {
+	params := map[string][]string{
+		"command": {"returnTicker"},
+	}
+	status, js, err := p.sendReq("GET", "/public", params, false)
+	if err != nil {
+		return
+	}
+
+	respOk := func(js *Json) (interface{}, error) {
+		reason, err := js.Get("error").String()
+		if err == nil {
+			return nil, errors.New(reason)
+		}
+
+		ticker, ok := js.CheckGet(p.ToSymbol(cp))
+		if !ok {
+			return nil, errors.New("No ticker for " + cp.String())
+		}
+		price, err := getFloat(ticker, "last")
+		if err != nil {
+			return nil, err
+		}
+		return Price{price}, nil
+	}
+
+	pr, err := ProcessResp(status, js, respOk, p.respErr)
+	if err == nil {
+		price = pr.(Price)
+	}
 	return
 }
 
@@ -90,10 +171,12 @@ func (p *Poloniex) GetSymbols() (symbols []string, err error) {
 			return nil, errors.New(reason)
 		}
 
-		data, _ := js.Map()
+		data, err := js.Map()
+		if err != nil {
+			return nil, errors.New("No symbols in response")
+		}
 		for symbol, _ := range data {
-			currency := strings.Split(symbol, "_")
-			s = append(s, strings.ToLower(currency[1]+"_"+currency[0]))
+			s = append(s, p.NormSymbol(&symbol))
 		}
 		return s, nil
 	}
@@ -118,25 +201,14 @@ func (p *Poloniex) GetDepth(cp *CurrencyPair) (depth Depth, err error) {
 	}
 
 	respOk := func(js *Json) (interface{}, error) {
-		var depth Depth
 		reason, err := js.Get("error").String()
 		if err == nil {
 			return nil, errors.New(reason)
 		}
 
-		asks, _ := js.Get("asks").Array()
-		for _, a := range asks {
-			uu := a.([]interface{})
-			price, _ := strconv.ParseFloat(uu[0].(string), 64)
-			amount, _ := uu[1].(json.Number).Float64()
-			depth.Asks = append([]Unit{Unit{price, amount}}, depth.Asks...)
-		}
-		bids, _ := js.Get("bids").Array()
-		for _, b := range bids {
-			uu := b.([]interface{})
-			price, _ := strconv.ParseFloat(uu[0].(string), 64)
-			amount, _ := uu[1].(json.Number).Float64()
-			depth.Bids = append(depth.Bids, Unit{price, amount})
+		depth, err := getDepth(js, "asks", "bids")
+		if err != nil {
+			return nil, err
 		}
 		return depth, nil
 	}
@@ -149,7 +221,11 @@ func (p *Poloniex) GetDepth(cp *CurrencyPair) (depth Depth, err error) {
 }
 
 func (p *Poloniex) OrderState(s interface{}) string {
-	return s.(string)
+	switch s {
+	case "Open", "Partially filled":
+		return Alive
+	}
+	return Unknown
 }
 
 func (p *Poloniex) OrderSide(s string) string {
@@ -157,17 +233,196 @@ func (p *Poloniex) OrderSide(s string) string {
 }
 
 func (p *Poloniex) NewOrder(o *Order) (id string, err error) {
+	params := map[string][]string{
+		"command":      {o.Side},
+		"currencyPair": {p.ToSymbol(&o.CP)},
+		"rate":         {strconv.FormatFloat(o.Price, 'f', -1, 64)},
+		"amount":       {strconv.FormatFloat(o.Amount, 'f', -1, 64)},
+	}
+
+	status, js, err := p.sendReq("POST", "/tradingApi", params, true)
+	if err != nil {
+		return
+	}
+
+	respOk := func(js *Json) (interface{}, error) {
+		reason, err := js.Get("error").String()
+		if err == nil {
+			return nil, errors.New(reason)
+		}
+
+		return getId(js, "orderNumber")
+	}
+
+	oid, err := ProcessResp(status, js, respOk, p.respErr)
+	if err == nil {
+		id = oid.(string)
+	}
 	return
 }
 
 func (p *Poloniex) CancelOrder(o *Order) (err error) {
+	params := map[string][]string{
+		"command":     {"cancelOrder"},
+		"orderNumber": {o.Id},
+	}
+
+	status, js, err := p.sendReq("POST", "/tradingApi", params, true)
+	if err != nil {
+		return
+	}
+
+	respOk := func(js *Json) (interface{}, error) {
+		reason, err := js.Get("error").String()
+		if err == nil {
+			return nil, errors.New(reason)
+		}
+		if success, _ := js.Get("success").Int(); success != 1 {
+			return nil, errors.New("Cancel failed")
+		}
+		return nil, nil
+	}
+
+	_, err = ProcessResp(status, js, respOk, p.respErr)
 	return
 }
 
+// QueryOrder asks the status of an open order. Poloniex forgets an order
+// once it is closed, which is then told by its trades: Filled if they add
+// up to the amount of o, or to anything when o has no amount, and
+// Cancelled otherwise.
 func (p *Poloniex) QueryOrder(o *Order) (order Order, err error) {
+	params := map[string][]string{
+		"command":     {"returnOrderStatus"},
+		"orderNumber": {o.Id},
+	}
+
+	status, js, err := p.sendReq("POST", "/tradingApi", params, true)
+	if err != nil {
+		return
+	}
+
+	respOk := func(js *Json) (interface{}, error) {
+		reason, err := js.Get("error").String()
+		if err == nil {
+			return nil, errors.New(reason)
+		}
+		if success, _ := js.Get("success").Int(); success != 1 {
+			return p.queryClosed(o)
+		}
+
+		var order Order
+		od, ok := js.Get("result").CheckGet(o.Id)
+		if !ok {
+			return nil, errors.New("No order " + o.Id)
+		}
+		order.Id = o.Id
+		symbol, err := getString(od, "currencyPair")
+		if err != nil {
+			return nil, err
+		}
+		order.CP = NewCurrencyPair2(p.NormSymbol(&symbol))
+		side, err := getString(od, "type")
+		if err != nil {
+			return nil, err
+		}
+		order.Side = p.OrderSide(side)
+		if order.Price, err = getFloat(od, "rate"); err != nil {
+			return nil, err
+		}
+		if order.Amount, err = getFloat(od, "startingAmount"); err != nil {
+			return nil, err
+		}
+		if order.Remain, err = getFloat(od, "amount"); err != nil {
+			return nil, err
+		}
+		order.Executed = order.Amount - order.Remain
+		state, err := getString(od, "status")
+		if err != nil {
+			return nil, err
+		}
+		order.State = p.OrderState(state)
+		return order, nil
+	}
+
+	od, err := ProcessResp(status, js, respOk, p.respErr)
+	if err == nil {
+		order = od.(Order)
+	}
 	return
 }
 
+// queryClosed builds closed order o from its trades
+func (p *Poloniex) queryClosed(o *Order) (interface{}, error) {
+	params := map[string][]string{
+		"command":     {"returnOrderTrades"},
+		"orderNumber": {o.Id},
+	}
+
+	status, js, err := p.sendReq("POST", "/tradingApi", params, true)
+	if err != nil {
+		return nil, err
+	}
+
+	respOk := func(js *Json) (interface{}, error) {
+		reason, err := js.Get("error").String()
+		if err == nil {
+			return nil, errors.New(reason)
+		}
+		trades, err := js.Array()
+		if err != nil {
+			return nil, errors.New("No trades of order " + o.Id)
+		}
+
+		order := Order{Id: o.Id, CP: o.CP, Side: o.Side, Price: o.Price}
+		var total float64
+		for _, t := range trades {
+			tt, ok := t.(map[string]interface{})
+			if !ok {
+				return nil, errors.New("No trades of order " + o.Id)
+			}
+			amount, err := toFloat(tt["amount"])
+			if err != nil {
+				return nil, err
+			}
+			rate, err := toFloat(tt["rate"])
+			if err != nil {
+				return nil, err
+			}
+			if symbol, ok := tt["currencyPair"].(string); ok {
+				order.CP = NewCurrencyPair2(p.NormSymbol(&symbol))
+			}
+			if side, ok := tt["type"].(string); ok {
+				order.Side = p.OrderSide(side)
+			}
+			order.Executed += amount
+			total += amount * rate
+		}
+		if order.Price == 0 && order.Executed > 0 {
+			order.Price = total / order.Executed
+		}
+		order.Amount = o.Amount
+		if order.Amount == 0 {
+			order.Amount = order.Executed
+		}
+		order.Remain = order.Amount - order.Executed
+		order.State = Cancelled
+		if order.Executed > 0 && order.Remain <= 0 {
+			order.State = Filled
+		}
+		return order, nil
+	}
+
+	return ProcessResp(status, js, respOk, p.respErr)
+}
+
+func (p *Poloniex) Capabilities() Capabilities {
+	return Capabilities{
+		Operations: BasicOperations,
+		OrderTypes: []string{LimitOrder},
+	}
+}
+
 func NewPoloniex() Exchange {
 	return new(Poloniex)
 }
@@ -5,51 +5,114 @@ import (
 	"crypto/cipher"
 	"crypto/hmac"
 	"crypto/md5"
+	"crypto/pbkdf2"
 	"crypto/rand"
+	"crypto/sha1"
 	"crypto/sha256"
 	"crypto/sha512"
 	"encoding/base64"
+	"encoding/binary"
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
 )
 
-func Decrypt(cipherstring string, keystring string) string {
-	// Byte array of the string
-	ciphertext := []byte(cipherstring)
+/*
+ * Encrypt seals plain with a key derived from passphrase, the result is
+ *
+ *   "BCEX" | version | PBKDF2 iterations | salt | nonce | AES-GCM ciphertext
+ *
+ * The header is authenticated along with the ciphertext, so a wrong
+ * passphrase or a modified file is detected by Decrypt.
+ */
+
+const (
+	cryptMagic   = "BCEX"
+	cryptVersion = 1
+	cryptSaltLen = 16
+	// PBKDF2-HMAC-SHA256 rounds used for new files
+	cryptIter = 200000
+)
 
-	// Key
-	key := []byte(keystring)
+var (
+	ErrWrongPassphrase = errors.New("Wrong passphrase or corrupted data")
+	// ErrUnversioned is returned by Decrypt on data without the header,
+	// which is written by versions before the header, see DecryptCFB
+	ErrUnversioned = errors.New("Data is not in a versioned format")
+)
 
-	// Create the AES cipher
+func newGCM(passphrase string, salt []byte, iter int) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, passphrase, salt, iter, 32)
+	if err != nil {
+		return nil, err
+	}
 	block, err := aes.NewCipher(key)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
+	return cipher.NewGCM(block)
+}
 
-	// Before even testing the decryption,
-	// if the text is too small, then it is incorrect
-	if len(ciphertext) < aes.BlockSize {
-		panic("Text is too short")
+func Encrypt(plainstring, passphrase string) (string, error) {
+	header := make([]byte, len(cryptMagic)+1+4+cryptSaltLen)
+	copy(header, cryptMagic)
+	header[len(cryptMagic)] = cryptVersion
+	binary.BigEndian.PutUint32(header[len(cryptMagic)+1:], cryptIter)
+	salt := header[len(cryptMagic)+5:]
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return "", err
 	}
 
-	// Get the 16 byte IV
-	iv := ciphertext[:aes.BlockSize]
-
-	// Remove the IV from the ciphertext
-	ciphertext = ciphertext[aes.BlockSize:]
+	aead, err := newGCM(passphrase, salt, cryptIter)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
 
-	// Return a decrypted stream
-	stream := cipher.NewCFBDecrypter(block, iv)
+	out := append(header, nonce...)
+	return string(aead.Seal(out, nonce, []byte(plainstring), header)), nil
+}
 
-	// Decrypt bytes from ciphertext
-	stream.XORKeyStream(ciphertext, ciphertext)
+func Decrypt(cipherstring, passphrase string) (string, error) {
+	data := []byte(cipherstring)
+	hlen := len(cryptMagic) + 1 + 4 + cryptSaltLen
+	if len(data) < hlen || string(data[:len(cryptMagic)]) != cryptMagic {
+		return "", ErrUnversioned
+	}
+	if v := data[len(cryptMagic)]; v != cryptVersion {
+		return "", fmt.Errorf("Unsupported format version %d", v)
+	}
+	header := data[:hlen]
+	iter := binary.BigEndian.Uint32(header[len(cryptMagic)+1:])
+	if iter == 0 || iter > 100*cryptIter {
+		return "", ErrWrongPassphrase
+	}
 
-	return string(ciphertext)
+	aead, err := newGCM(passphrase, header[len(cryptMagic)+5:], int(iter))
+	if err != nil {
+		return "", err
+	}
+	data = data[hlen:]
+	if len(data) < aead.NonceSize() {
+		return "", ErrWrongPassphrase
+	}
+	plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], header)
+	if err != nil {
+		return "", ErrWrongPassphrase
+	}
+	return string(plain), nil
 }
 
-func Encrypt(plainstring, keystring string) string {
+// DecryptCFB decrypts data written before the versioned format, with
+// AES-CFB and the raw 16, 24 or 32 bytes key. There is no integrity check,
+// a wrong key gives garbage.
+func DecryptCFB(cipherstring string, keystring string) (string, error) {
 	// Byte array of the string
-	plaintext := []byte(plainstring)
+	ciphertext := []byte(cipherstring)
 
 	// Key
 	key := []byte(keystring)
@@ -57,28 +120,28 @@ func Encrypt(plainstring, keystring string) string {
 	// Create the AES cipher
 	block, err := aes.NewCipher(key)
 	if err != nil {
-		panic(err)
+		return "", err
 	}
 
-	// Empty array of 16 + plaintext length
-	// Include the IV at the beginning
-	ciphertext := make([]byte, aes.BlockSize+len(plaintext))
+	// Before even testing the decryption,
+	// if the text is too small, then it is incorrect
+	if len(ciphertext) < aes.BlockSize {
+		return "", errors.New("Text is too short")
+	}
 
-	// Slice of first 16 bytes
+	// Get the 16 byte IV
 	iv := ciphertext[:aes.BlockSize]
 
-	// Write 16 rand bytes to fill iv
-	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
-		panic(err)
-	}
+	// Remove the IV from the ciphertext
+	ciphertext = ciphertext[aes.BlockSize:]
 
-	// Return an encrypted stream
-	stream := cipher.NewCFBEncrypter(block, iv)
+	// Return a decrypted stream
+	stream := cipher.NewCFBDecrypter(block, iv)
 
-	// Encrypt bytes from plaintext to ciphertext
-	stream.XORKeyStream(ciphertext[aes.BlockSize:], plaintext)
+	// Decrypt bytes from ciphertext
+	stream.XORKeyStream(ciphertext, ciphertext)
 
-	return string(ciphertext)
+	return string(ciphertext), nil
 }
 
 func ComputeHmac256(message string, secret string) string {
@@ -101,6 +164,19 @@ func GetMD5Hash(text string) string {
 	return hex.EncodeToString(hasher.Sum(nil))
 }
 
+func GetSHA1Hash(text string) string {
+	hasher := sha1.New()
+	hasher.Write([]byte(text))
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+func ComputeHmacMD5(message string, secret string) string {
+	key := []byte(secret)
+	h := hmac.New(md5.New, key)
+	h.Write([]byte(message))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
 func GetParamHmacSha384Sign(secret, params string) string {
 	mac := hmac.New(sha512.New384, []byte(secret))
 	_, err := mac.Write([]byte(params))
@@ -136,3 +212,10 @@ func GetUUID() string {
 	rand.Read(uuid.Bytes())
 	return uuid.String()
 }
+
+func ComputeHmac512(message string, secret string) string {
+	key := []byte(secret)
+	h := hmac.New(sha512.New, key)
+	h.Write([]byte(message))
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,40 @@
+package lib
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"testing"
+)
+
+func TestEncrypt(t *testing.T) {
+	raw, err := Encrypt(`{"binance":{}}`, "any passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain, err := Decrypt(raw, "any passphrase"); err != nil || plain != `{"binance":{}}` {
+		t.Errorf("Decrypt = %q, %v", plain, err)
+	}
+	if _, err := Decrypt(raw, "wrong passphrase"); err != ErrWrongPassphrase {
+		t.Errorf("Decrypt with wrong passphrase = %v", err)
+	}
+
+	tampered := []byte(raw)
+	tampered[len(cryptMagic)+6] ^= 1
+	if _, err := Decrypt(string(tampered), "any passphrase"); err != ErrWrongPassphrase {
+		t.Errorf("Decrypt of modified salt = %v", err)
+	}
+}
+
+func TestDecryptCFB(t *testing.T) {
+	key := "0123456789abcdef"
+	block, _ := aes.NewCipher([]byte(key))
+	old := make([]byte, aes.BlockSize+len("{}"))
+	cipher.NewCFBEncrypter(block, old[:aes.BlockSize]).XORKeyStream(old[aes.BlockSize:], []byte("{}"))
+
+	if _, err := Decrypt(string(old), key); err != ErrUnversioned {
+		t.Fatalf("Decrypt of CFB data = %v", err)
+	}
+	if plain, err := DecryptCFB(string(old), key); err != nil || plain != "{}" {
+		t.Errorf("DecryptCFB = %q, %v", plain, err)
+	}
+}
@@ -0,0 +1,296 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Fill struct {
+	OrderId string
+	CP      CurrencyPair
+	Side    string
+	Price   float64
+	Amount  float64
+	Fee     float64
+	Time    time.Time
+}
+
+/*
+ * SimAccount is the virtual account behind the simulated exchanges. Funds
+ * for open orders are moved to Frozen when placed, fees are charged on the
+ * currency received. Taken is the liquidity of each pair's book the orders
+ * have taken, which the book they are matched against still shows. It has
+ * only exported fields so it could be saved as json.
+ */
+type SimAccount struct {
+	Balances map[string]float64
+	Frozen   map[string]float64
+	Orders   map[string]*Order
+	Fills    []Fill
+	Taken    map[string]Depth
+	Fee      float64
+	NextId   int64
+}
+
+func NewSimAccount(fee float64) *SimAccount {
+	return &SimAccount{
+		Balances: map[string]float64{},
+		Frozen:   map[string]float64{},
+		Orders:   map[string]*Order{},
+		Taken:    map[string]Depth{},
+		Fee:      fee,
+	}
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func orderCurrencies(cp *CurrencyPair) (base, quote string) {
+	return strings.ToLower(cp.CurrencyA.Symbol), strings.ToLower(cp.CurrencyB.Symbol)
+}
+
+// the currency and the amount locked by amount of order o
+func orderCost(o *Order, amount float64) (string, float64) {
+	base, quote := orderCurrencies(&o.CP)
+	if o.Side == "buy" {
+		return quote, amount * o.Price
+	}
+	return base, amount
+}
+
+func (sa *SimAccount) Deposit(currency string, amount float64) {
+	sa.Balances[strings.ToLower(currency)] += amount
+}
+
+func (sa *SimAccount) Total(currency string) float64 {
+	currency = strings.ToLower(currency)
+	return sa.Balances[currency] + sa.Frozen[currency]
+}
+
+func (sa *SimAccount) GetBalance() (balances []Balance) {
+	var currencies []string
+	for c, _ := range sa.Balances {
+		currencies = append(currencies, c)
+	}
+	for c, _ := range sa.Frozen {
+		if _, ok := sa.Balances[c]; !ok {
+			currencies = append(currencies, c)
+		}
+	}
+	sort.Strings(currencies)
+
+	for _, c := range currencies {
+		if sa.Total(c) == 0 {
+			continue
+		}
+		balances = append(balances,
+			Balance{Currency: c, Balance: formatFloat(sa.Balances[c])})
+	}
+	return
+}
+
+func (sa *SimAccount) Place(o *Order) (id string, err error) {
+	if o.Side != "buy" && o.Side != "sell" {
+		return "", errors.New("Invalid side: " + o.Side)
+	}
+	if o.Price <= 0 || o.Amount <= 0 {
+		return "", errors.New("Invalid price or amount")
+	}
+
+	currency, need := orderCost(o, o.Amount)
+	if sa.Balances[currency] < need {
+		return "", errors.New("Insufficient " + currency + " balance")
+	}
+	sa.Balances[currency] -= need
+	sa.Frozen[currency] += need
+
+	sa.NextId++
+	order := *o
+	order.Id = strconv.FormatInt(sa.NextId, 10)
+	order.State = Alive
+	order.Executed = 0
+	order.Remain = order.Amount
+	sa.Orders[order.Id] = &order
+	return order.Id, nil
+}
+
+func (sa *SimAccount) aliveOrder(id string) (*Order, error) {
+	o, ok := sa.Orders[id]
+	if !ok {
+		return nil, errors.New("No such order: " + id)
+	}
+	if o.State != Alive {
+		return nil, errors.New("Order " + id + " is " + o.State)
+	}
+	return o, nil
+}
+
+func (sa *SimAccount) Cancel(id string) error {
+	o, err := sa.aliveOrder(id)
+	if err != nil {
+		return err
+	}
+
+	currency, locked := orderCost(o, o.Remain)
+	sa.Frozen[currency] -= locked
+	sa.Balances[currency] += locked
+	o.State = Cancelled
+	return nil
+}
+
+func (sa *SimAccount) Query(id string) (order Order, err error) {
+	o, ok := sa.Orders[id]
+	if !ok {
+		return order, errors.New("No such order: " + id)
+	}
+	return *o, nil
+}
+
+// Fill executes amount of order id at price, which must not be worse
+// than the order price.
+func (sa *SimAccount) Fill(id string, price, amount float64, t time.Time) error {
+	o, err := sa.aliveOrder(id)
+	if err != nil {
+		return err
+	}
+	if !(price > 0) || o.Side == "buy" && price > o.Price ||
+		o.Side == "sell" && price < o.Price {
+		return fmt.Errorf("Fill price %v is beyond %s order %s at %v",
+			price, o.Side, id, o.Price)
+	}
+	if math.IsNaN(amount) {
+		return errors.New("Invalid fill amount")
+	}
+	amount = math.Min(amount, o.Remain)
+	if amount <= 0 {
+		return nil
+	}
+
+	var fee float64
+	base, quote := orderCurrencies(&o.CP)
+	if o.Side == "buy" {
+		fee = amount * sa.Fee
+		sa.Frozen[quote] -= amount * o.Price
+		sa.Balances[quote] += amount * (o.Price - price)
+		sa.Balances[base] += amount - fee
+	} else {
+		fee = amount * price * sa.Fee
+		sa.Frozen[base] -= amount
+		sa.Balances[quote] += amount*price - fee
+	}
+
+	o.Executed += amount
+	o.Remain = o.Amount - o.Executed
+	if o.Remain <= o.Amount*1e-12 {
+		o.Remain = 0
+		o.State = Filled
+	}
+
+	sa.Fills = append(sa.Fills, Fill{OrderId: id, CP: o.CP, Side: o.Side,
+		Price: price, Amount: amount, Fee: fee, Time: t})
+	return nil
+}
+
+// OpenOrders returns ids of alive orders on cp, oldest first.
+func (sa *SimAccount) OpenOrders(cp *CurrencyPair) (ids []string) {
+	for id, o := range sa.Orders {
+		if o.State == Alive && strings.EqualFold(o.CP.String(), cp.String()) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, _ := strconv.ParseInt(ids[i], 10, 64)
+		b, _ := strconv.ParseInt(ids[j], 10, 64)
+		return a < b
+	})
+	return
+}
+
+// Match fills the open orders on cp which cross depth, at the price of
+// the levels they take. What earlier matches took from a level is not
+// there any more, as long as the level is in depth.
+func (sa *SimAccount) Match(cp *CurrencyPair, depth Depth, t time.Time) {
+	key := strings.ToLower(cp.String())
+	taken := sa.Taken[key]
+	asks := sortUnits(depth.Asks, true)
+	bids := sortUnits(depth.Bids, false)
+	takenAsks := takeUnits(asks, taken.Asks)
+	takenBids := takeUnits(bids, taken.Bids)
+
+	for _, id := range sa.OpenOrders(cp) {
+		o := sa.Orders[id]
+		book, took := asks, takenAsks
+		if o.Side == "sell" {
+			book, took = bids, takenBids
+		}
+		for i := range book {
+			if o.State != Alive {
+				break
+			}
+			if o.Side == "buy" && book[i].Price > o.Price ||
+				o.Side == "sell" && book[i].Price < o.Price {
+				break
+			}
+			amount := math.Min(o.Remain, book[i].Amount)
+			if amount <= 0 {
+				continue
+			}
+			sa.Fill(id, book[i].Price, amount, t)
+			book[i].Amount -= amount
+			took[i].Amount += amount
+		}
+	}
+
+	if sa.Taken == nil {
+		sa.Taken = map[string]Depth{}
+	}
+	taken = Depth{Asks: nonEmptyUnits(takenAsks), Bids: nonEmptyUnits(takenBids)}
+	if len(taken.Asks) == 0 && len(taken.Bids) == 0 {
+		delete(sa.Taken, key)
+	} else {
+		sa.Taken[key] = taken
+	}
+}
+
+// takeUnits removes taken from the levels of book and returns what was
+// taken from each of them, taken of a level not in book is dropped.
+func takeUnits(book, taken []Unit) (took []Unit) {
+	took = make([]Unit, len(book))
+	for i, u := range book {
+		took[i].Price = u.Price
+		for _, tu := range taken {
+			if tu.Price == u.Price {
+				took[i].Amount = math.Min(tu.Amount, u.Amount)
+			}
+		}
+		book[i].Amount -= took[i].Amount
+	}
+	return
+}
+
+func nonEmptyUnits(units []Unit) (nonEmpty []Unit) {
+	for _, u := range units {
+		if u.Amount > 0 {
+			nonEmpty = append(nonEmpty, u)
+		}
+	}
+	return
+}
+
+// sortUnits returns a sorted copy of units, best price first.
+func sortUnits(units []Unit, ascending bool) []Unit {
+	sorted := append([]Unit(nil), units...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if ascending {
+			return sorted[i].Price < sorted[j].Price
+		}
+		return sorted[i].Price > sorted[j].Price
+	})
+	return sorted
+}
@@ -0,0 +1,115 @@
+package lib
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+var simPair = NewCurrencyPair2("eth_btc")
+
+func newTestAccount(fee float64) *SimAccount {
+	sa := NewSimAccount(fee)
+	sa.Deposit("btc", 1)
+	sa.Deposit("eth", 10)
+	return sa
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-12
+}
+
+func TestSimAccountFill(t *testing.T) {
+	cases := []struct {
+		name       string
+		fee        float64
+		side       string
+		fillPrice  float64
+		fillAmount float64
+		wantErr    bool
+		state      string
+		btc, eth   float64 // available
+		frozen     float64 // of the currency paid
+		fillFee    float64 // of the fill
+	}{
+		{"buy all", 0, "buy", 0.05, 2, false, Filled, 0.9, 12, 0, 0},
+		{"buy part better", 0.001, "buy", 0.04, 1, false, Alive, 0.91, 10.999, 0.05, 0.001},
+		{"buy more than remain", 0, "buy", 0.05, 3, false, Filled, 0.9, 12, 0, 0},
+		{"sell all better", 0.001, "sell", 0.06, 2, false, Filled, 1.11988, 8, 0, 0.00012},
+		{"sell part", 0, "sell", 0.05, 0.5, false, Alive, 1.025, 8, 1.5, 0},
+		{"buy above price", 0, "buy", 0.06, 1, true, Alive, 0.9, 10, 0.1, 0},
+		{"sell below price", 0, "sell", 0.04, 1, true, Alive, 1, 8, 2, 0},
+		{"zero price", 0, "buy", 0, 1, true, Alive, 0.9, 10, 0.1, 0},
+		{"nan amount", 0, "buy", 0.05, math.NaN(), true, Alive, 0.9, 10, 0.1, 0},
+	}
+	for _, c := range cases {
+		sa := newTestAccount(c.fee)
+		id, err := sa.Place(&Order{CP: simPair, Side: c.side, Price: 0.05, Amount: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = sa.Fill(id, c.fillPrice, c.fillAmount, time.Now())
+		if (err != nil) != c.wantErr {
+			t.Errorf("%s: Fill = %v", c.name, err)
+		}
+
+		o, _ := sa.Query(id)
+		paid := "btc"
+		if c.side == "sell" {
+			paid = "eth"
+		}
+		if o.State != c.state || !near(sa.Balances["btc"], c.btc) ||
+			!near(sa.Balances["eth"], c.eth) || !near(sa.Frozen[paid], c.frozen) {
+			t.Errorf("%s: order %s, balances %v, frozen %v", c.name, o.State, sa.Balances, sa.Frozen)
+		}
+		if n := len(sa.Fills); c.wantErr && n != 0 || !c.wantErr && (n != 1 || !near(sa.Fills[0].Fee, c.fillFee)) {
+			t.Errorf("%s: fills %v", c.name, sa.Fills)
+		}
+	}
+}
+
+func TestSimAccountCancel(t *testing.T) {
+	sa := newTestAccount(0)
+	id, _ := sa.Place(&Order{CP: simPair, Side: "buy", Price: 0.05, Amount: 2})
+	sa.Fill(id, 0.05, 1, time.Now())
+
+	if err := sa.Cancel(id); err != nil {
+		t.Fatal(err)
+	}
+	if o, _ := sa.Query(id); o.State != Cancelled || o.Remain != 1 {
+		t.Errorf("cancelled order = %+v", o)
+	}
+	if !near(sa.Balances["btc"], 0.95) || sa.Frozen["btc"] != 0 || sa.Balances["eth"] != 11 {
+		t.Errorf("balances %v, frozen %v", sa.Balances, sa.Frozen)
+	}
+	if sa.Cancel(id) == nil || sa.Fill(id, 0.05, 1, time.Now()) == nil {
+		t.Error("cancelled order is cancelled or filled again")
+	}
+}
+
+func TestSimAccountBalance(t *testing.T) {
+	sa := NewSimAccount(0)
+	sa.Deposit("BTC", 0.1)
+
+	cases := []struct {
+		o  Order
+		ok bool
+	}{
+		{Order{CP: simPair, Side: "buy", Price: 0.05, Amount: 3}, false},
+		{Order{CP: simPair, Side: "hold", Price: 0.05, Amount: 1}, false},
+		{Order{CP: simPair, Side: "buy", Price: 0, Amount: 1}, false},
+		{Order{CP: simPair, Side: "sell", Price: 0.05, Amount: 1}, false},
+		{Order{CP: simPair, Side: "buy", Price: 0.05, Amount: 2}, true},
+	}
+	for _, c := range cases {
+		if _, err := sa.Place(&c.o); (err == nil) != c.ok {
+			t.Errorf("Place %+v = %v", c.o, err)
+		}
+	}
+
+	// all btc is frozen in the order, which still shows
+	balances := sa.GetBalance()
+	if len(balances) != 1 || balances[0] != (Balance{"btc", "0"}) || sa.Total("btc") != 0.1 {
+		t.Errorf("GetBalance = %v", balances)
+	}
+}
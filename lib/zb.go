@@ -1,20 +1,36 @@
 package lib
 
 import (
-	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	. "github.com/bitly/go-simplejson"
 )
 
+/*
+ * Reference page: https://www.zb.com/i/developer
+ */
+
+// code of the responses which succeed
+const zbSuccess = "1000"
+
 type ZB struct {
 	accesskeyid, secretkeyid string
 }
 
 func (zb *ZB) respErr(js *Json) (interface{}, error) {
-	return nil, nil
+	if reason, err := js.Get("error").String(); err == nil {
+		return nil, errors.New(reason)
+	}
+	if reason, err := js.Get("message").String(); err == nil {
+		return nil, errors.New(reason)
+	}
+	return nil, errors.New(Unknown)
 }
 
 func (zb *ZB) ToSymbol(cp *CurrencyPair) string {
@@ -22,7 +38,7 @@ func (zb *ZB) ToSymbol(cp *CurrencyPair) string {
 }
 
 func (zb *ZB) NormSymbol(cp *string) string {
-	return *cp
+	return strings.ToLower(*cp)
 }
 
 func (zb *ZB) sendReq(method, path string,
@@ -36,22 +52,22 @@ func (zb *ZB) sendReq(method, path string,
 		Header: header,
 	}
 
-	req.URL, _ = url.Parse("http://api.zb.com" + path)
 	if sign {
-		sign_params := map[string][]string{
-			"ex_key": {zb.accesskeyid},
+		req.URL, _ = url.Parse("https://trade.zb.com" + path)
+		q := url.Values{
+			"accesskey": {zb.accesskeyid},
+			"method":    {path[strings.LastIndex(path, "/")+1:]},
 		}
-
 		for k, v := range params {
-			sign_params[k] = v
+			q[k] = v
 		}
-
-		q := req.URL.Query()
-		q = sign_params
-		data := q.Encode() + "&secret_key=" + zb.secretkeyid
-		q.Add("sign", strings.ToUpper(GetMD5Hash(data)))
+		// sign with the sha1 of the secret, then add reqTime
+		data := q.Encode()
+		q.Add("sign", ComputeHmacMD5(data, GetSHA1Hash(zb.secretkeyid)))
+		q.Add("reqTime", strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10))
 		req.URL.RawQuery = q.Encode()
 	} else {
+		req.URL, _ = url.Parse("https://api.zb.com" + path)
 		q := req.URL.Query()
 		q = params
 		req.URL.RawQuery = q.Encode()
@@ -64,11 +80,84 @@ func (zb *ZB) SetKey(access, secret string) {
 	zb.secretkeyid = secret
 }
 
+// GetBalance returns available and frozen of each currency held
 func (zb *ZB) GetBalance() (balances []Balance, err error) {
+	status, js, err := zb.sendReq("GET", "/api/getAccountInfo", nil, true)
+	if err != nil {
+		return
+	}
+
+	respOk := func(js *Json) (interface{}, error) {
+		if failedCode(js, zbSuccess) {
+			return zb.respErr(js)
+		}
+
+		var balances []Balance
+		coins, err := toArray(js.Get("result").Get("coins").Interface())
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range coins {
+			cc, err := toMap(c)
+			if err != nil {
+				return nil, err
+			}
+			key, err := toString(cc["key"])
+			if err != nil {
+				return nil, err
+			}
+			available, err := toFloat(cc["available"])
+			if err != nil {
+				return nil, err
+			}
+			freez, err := toFloat(cc["freez"])
+			if err != nil {
+				return nil, err
+			}
+			total := available + freez
+			if total == 0 {
+				continue
+			}
+			balances = append(balances,
+				Balance{Currency: strings.ToLower(key),
+					Balance: strconv.FormatFloat(total, 'f', -1, 64)})
+		}
+		return balances, nil
+	}
+
+	b, err := ProcessResp(status, js, respOk, zb.respErr)
+	if err == nil {
+		balances = b.([]Balance)
+	}
 	return
 }
 
 func (zb *ZB) GetPrice(cp *CurrencyPair) (price Price, err error) {
+	params := map[string][]string{
+		"market": {zb.ToSymbol(cp)},
+	}
+
+	status, js, err := zb.sendReq("GET", "/data/v1/ticker", params, false)
+	if err != nil {
+		return
+	}
+
+	respOk := func(js *Json) (interface{}, error) {
+		if failedCode(js, zbSuccess) {
+			return zb.respErr(js)
+		}
+
+		price, err := getFloat(js.Get("ticker"), "last")
+		if err != nil {
+			return nil, err
+		}
+		return Price{price}, nil
+	}
+
+	p, err := ProcessResp(status, js, respOk, zb.respErr)
+	if err == nil {
+		price = p.(Price)
+	}
 	return
 }
 
@@ -80,7 +169,10 @@ func (zb *ZB) GetSymbols() (symbols []string, err error) {
 
 	respOk := func(js *Json) (interface{}, error) {
 		var s []string
-		data, _ := js.Map()
+		data, err := js.Map()
+		if err != nil {
+			return nil, errors.New("No symbols in response")
+		}
 		for symbol, _ := range data {
 			s = append(s, symbol)
 		}
@@ -106,21 +198,15 @@ func (zb *ZB) GetDepth(cp *CurrencyPair) (depth Depth, err error) {
 	}
 
 	respOk := func(js *Json) (interface{}, error) {
-		var depth Depth
-		asks, _ := js.Get("asks").Array()
-		for _, a := range asks {
-			uu := a.([]interface{})
-			price, _ := uu[0].(json.Number).Float64()
-			amount, _ := uu[1].(json.Number).Float64()
-			depth.Asks = append(depth.Asks, Unit{price, amount})
-		}
-		bids, _ := js.Get("bids").Array()
-		for _, b := range bids {
-			uu := b.([]interface{})
-			price, _ := uu[0].(json.Number).Float64()
-			amount, _ := uu[1].(json.Number).Float64()
-			depth.Bids = append(depth.Bids, Unit{price, amount})
+		if failedCode(js, zbSuccess) {
+			return zb.respErr(js)
 		}
+
+		depth, err := getDepth(js, "asks", "bids")
+		if err != nil {
+			return nil, err
+		}
+		depth.Asks = reverseUnits(depth.Asks)
 		return depth, nil
 	}
 
@@ -132,25 +218,141 @@ func (zb *ZB) GetDepth(cp *CurrencyPair) (depth Depth, err error) {
 }
 
 func (zb *ZB) OrderState(s interface{}) string {
-	return s.(string)
+	switch s {
+	case 0, 3:
+		return Alive
+	case 1:
+		return Cancelled
+	case 2:
+		return Filled
+	}
+	return Unknown
 }
 
+// OrderSide converts tradeType of an order, 1 is buy and 0 is sell
 func (zb *ZB) OrderSide(s string) string {
+	switch s {
+	case "1":
+		return "buy"
+	case "0":
+		return "sell"
+	}
 	return s
 }
 
 func (zb *ZB) NewOrder(o *Order) (id string, err error) {
+	tradeType := "0"
+	if o.Side == "buy" {
+		tradeType = "1"
+	}
+	params := map[string][]string{
+		"currency":  {zb.ToSymbol(&o.CP)},
+		"tradeType": {tradeType},
+		"price":     {strconv.FormatFloat(o.Price, 'f', -1, 64)},
+		"amount":    {strconv.FormatFloat(o.Amount, 'f', -1, 64)},
+	}
+
+	status, js, err := zb.sendReq("GET", "/api/order", params, true)
+	if err != nil {
+		return
+	}
+
+	respOk := func(js *Json) (interface{}, error) {
+		if failedCode(js, zbSuccess) {
+			return zb.respErr(js)
+		}
+
+		return getId(js, "id")
+	}
+
+	oid, err := ProcessResp(status, js, respOk, zb.respErr)
+	if err == nil {
+		id = oid.(string)
+	}
 	return
 }
 
 func (zb *ZB) CancelOrder(o *Order) (err error) {
+	params := map[string][]string{
+		"id":       {o.Id},
+		"currency": {zb.ToSymbol(&o.CP)},
+	}
+
+	status, js, err := zb.sendReq("GET", "/api/cancelOrder", params, true)
+	if err != nil {
+		return
+	}
+
+	respOk := func(js *Json) (interface{}, error) {
+		if failedCode(js, zbSuccess) {
+			return zb.respErr(js)
+		}
+		return nil, nil
+	}
+
+	_, err = ProcessResp(status, js, respOk, zb.respErr)
 	return
 }
 
 func (zb *ZB) QueryOrder(o *Order) (order Order, err error) {
+	params := map[string][]string{
+		"id":       {o.Id},
+		"currency": {zb.ToSymbol(&o.CP)},
+	}
+
+	status, js, err := zb.sendReq("GET", "/api/getOrder", params, true)
+	if err != nil {
+		return
+	}
+
+	respOk := func(js *Json) (interface{}, error) {
+		if failedCode(js, zbSuccess) {
+			return zb.respErr(js)
+		}
+
+		var order Order
+		var err error
+		if order.Id, err = getId(js, "id"); err != nil {
+			return nil, err
+		}
+		symbol, err := getString(js, "currency")
+		if err != nil {
+			return nil, err
+		}
+		order.CP = NewCurrencyPair2(zb.NormSymbol(&symbol))
+		order.Side = zb.OrderSide(fmt.Sprint(js.Get("type").Interface()))
+		if order.Price, err = toFloat(js.Get("price").Interface()); err != nil {
+			return nil, err
+		}
+		if order.Amount, err = toFloat(js.Get("total_amount").Interface()); err != nil {
+			return nil, err
+		}
+		if order.Executed, err = toFloat(js.Get("trade_amount").Interface()); err != nil {
+			return nil, err
+		}
+		order.Remain = order.Amount - order.Executed
+		state, err := js.Get("status").Int()
+		if err != nil {
+			return nil, errors.New("No status in response")
+		}
+		order.State = zb.OrderState(state)
+		return order, nil
+	}
+
+	od, err := ProcessResp(status, js, respOk, zb.respErr)
+	if err == nil {
+		order = od.(Order)
+	}
 	return
 }
 
+func (zb *ZB) Capabilities() Capabilities {
+	return Capabilities{
+		Operations: BasicOperations,
+		OrderTypes: []string{LimitOrder},
+	}
+}
+
 func NewZB() Exchange {
 	return new(ZB)
 }